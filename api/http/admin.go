@@ -0,0 +1,135 @@
+package http
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/render"
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+)
+
+// CacheEntry describes stored forecast in admin API
+type CacheEntry struct {
+	City                string    `json:"city"`
+	Source              string    `json:"source"`
+	StoredAt            time.Time `json:"stored_at"`
+	AgeSeconds          int64     `json:"age_seconds"`
+	TTLRemainingSeconds *int64    `json:"ttl_remaining_seconds,omitempty"` // nil if entry never expires
+}
+
+// CacheEntries is a list of stored forecasts
+type CacheEntries struct {
+	Entries []CacheEntry `json:"entries"`
+}
+
+// WithAdmin enables /admin endpoints for given storage, protected by bearer token
+func WithAdmin(token string, storage weathersrc.WriteableForecastProvider) Option {
+	return func(api *HTTPApi) {
+		api.AdminToken = token
+		api.Storage = storage
+	}
+}
+
+// adminRouter returns router for /admin endpoints
+func (a *HTTPApi) adminRouter() http.Handler {
+	r := chi.NewRouter()
+	r.Use(a.requireAdminToken)
+	r.Get("/cache", a.ListCache)
+	r.Delete("/cache", a.FlushCache)
+	r.Delete("/cache/{city}", a.DeleteCache)
+	return r
+}
+
+func (a *HTTPApi) requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			renderError(w, r, &ErrResponse{
+				Err:            nil,
+				HTTPStatusCode: http.StatusUnauthorized,
+				StatusText:     "unauthorized",
+			})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized tells if request carries admin token
+func (a *HTTPApi) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return a.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.AdminToken)) == 1
+}
+
+// ListCache returns all stored forecasts with their age and remaining TTL
+func (a *HTTPApi) ListCache(w http.ResponseWriter, r *http.Request) {
+	entries, err := a.Storage.ListForecasts()
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	response := &CacheEntries{Entries: make([]CacheEntry, 0, len(entries))}
+	for _, e := range entries {
+		entry := CacheEntry{
+			City:       e.City,
+			Source:     e.Source,
+			StoredAt:   e.StoredAt,
+			AgeSeconds: int64(now.Sub(e.StoredAt).Seconds()),
+		}
+		if !e.ExpiresAt.IsZero() {
+			ttl := int64(e.ExpiresAt.Sub(now).Seconds())
+			entry.TTLRemainingSeconds = &ttl
+		}
+		response.Entries = append(response.Entries, entry)
+	}
+
+	render.JSON(w, r, response)
+}
+
+// DeleteCache evicts forecasts for a single city, in all units and languages
+func (a *HTTPApi) DeleteCache(w http.ResponseWriter, r *http.Request) {
+	city := chi.URLParam(r, "city")
+
+	if err := weathersrc.DeleteCity(a.Storage, city); err != nil {
+		if errors.Is(err, weather.ErrForecastNotFound) {
+			renderError(w, r, &ErrResponse{
+				Err:            err,
+				HTTPStatusCode: http.StatusNotFound,
+				StatusText:     weather.ErrForecastNotFound.Error(),
+			})
+			return
+		}
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// FlushCache removes all stored forecasts
+func (a *HTTPApi) FlushCache(w http.ResponseWriter, r *http.Request) {
+	if err := a.Storage.Flush(); err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/papisz/weather"
+)
+
+const (
+	defaultMaxBatchSize = 100
+	maxBatchBodyBytes   = 1 << 20
+)
+
+var validUnits = map[string]bool{"standard": true, "metric": true, "imperial": true}
+
+// LocationQuery is a single location in POST /forecast request. Location is
+// given by exactly one of Name, ID or Coord.
+type LocationQuery struct {
+	Name  string         `json:"name,omitempty"`
+	ID    int            `json:"id,omitempty"`
+	Coord *weather.Coord `json:"coord,omitempty"`
+	Units string         `json:"units,omitempty"`
+	Lang  string         `json:"lang,omitempty"`
+}
+
+// BatchRequest is a body of POST /forecast
+type BatchRequest struct {
+	Locations []LocationQuery `json:"locations"`
+}
+
+// WithMaxBatchSize limits number of locations in a single /forecast request
+func WithMaxBatchSize(size int) Option {
+	return func(api *HTTPApi) {
+		api.MaxBatchSize = size
+	}
+}
+
+// PostForecasts returns forecasts for locations given in JSON body. Forecasts
+// are keyed by location: name, "id:<id>" or "<lat>,<lon>".
+func (a *HTTPApi) PostForecasts(w http.ResponseWriter, r *http.Request) {
+	f, err := negotiateFormat(r)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusNotAcceptable,
+			StatusText:     errNotAcceptable.Error(),
+		})
+		return
+	}
+
+	maxAge, err := parseMaxAge(r)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "invalid max_age",
+			InvalidParams:  []InvalidParam{{Name: "max_age", Reason: err.Error()}},
+		})
+		return
+	}
+
+	refresh, errResp := a.parseRefresh(r)
+	if errResp != nil {
+		renderError(w, r, errResp)
+		return
+	}
+
+	var req BatchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBodyBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "unable to parse request body",
+			ErrorText:      err.Error(),
+		})
+		return
+	}
+
+	queries, invalid := a.validateBatch(req)
+	if len(invalid) > 0 {
+		renderError(w, r, &ErrResponse{
+			Err:            nil,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "invalid locations",
+			InvalidParams:  invalid,
+		})
+		return
+	}
+
+	for i := range queries {
+		queries[i].MaxAge = maxAge
+		queries[i].Refresh = refresh
+	}
+
+	forecasts, err := a.WeatherManager.QueryForecasts(queries...)
+	if err != nil {
+		renderManagerError(w, r, err)
+		return
+	}
+
+	body, err := f.encode(forecasts)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", f.contentType)
+	w.Write(body)
+}
+
+// validateBatch converts request to queries, listing every invalid input
+func (a *HTTPApi) validateBatch(req BatchRequest) ([]weather.Query, []InvalidParam) {
+	var invalid []InvalidParam
+
+	switch {
+	case len(req.Locations) == 0:
+		return nil, []InvalidParam{{Name: "locations", Reason: "at least one location is required"}}
+	case a.MaxBatchSize > 0 && len(req.Locations) > a.MaxBatchSize:
+		return nil, []InvalidParam{{Name: "locations", Reason: fmt.Sprintf("at most %d locations are allowed", a.MaxBatchSize)}}
+	}
+
+	queries := make([]weather.Query, 0, len(req.Locations))
+	seen := map[string]bool{}
+	for i, l := range req.Locations {
+		name := func(field string) string {
+			return fmt.Sprintf("locations[%d]%s", i, field)
+		}
+
+		given := 0
+		for _, ok := range []bool{l.Name != "", l.ID != 0, l.Coord != nil} {
+			if ok {
+				given++
+			}
+		}
+		if given != 1 {
+			invalid = append(invalid, InvalidParam{Name: name(""), Reason: "exactly one of name, id or coord is required"})
+			continue
+		}
+
+		q := weather.Query{City: l.Name, ID: l.ID, Coord: l.Coord, Units: l.Units}
+		if l.Name != "" {
+			city, reason := validateCity(l.Name)
+			if reason != "" {
+				invalid = append(invalid, InvalidParam{Name: name(".name"), Reason: reason})
+			}
+			q.City = city
+		}
+		if l.ID < 0 {
+			invalid = append(invalid, InvalidParam{Name: name(".id"), Reason: "must be positive"})
+		}
+		if l.Coord != nil && (l.Coord.Lat < -90 || l.Coord.Lat > 90) {
+			invalid = append(invalid, InvalidParam{Name: name(".coord.lat"), Reason: "must be between -90 and 90"})
+		}
+		if l.Coord != nil && (l.Coord.Lon < -180 || l.Coord.Lon > 180) {
+			invalid = append(invalid, InvalidParam{Name: name(".coord.lon"), Reason: "must be between -180 and 180"})
+		}
+		if l.Units != "" && !validUnits[l.Units] {
+			invalid = append(invalid, InvalidParam{Name: name(".units"), Reason: "must be one of standard, metric or imperial"})
+		}
+
+		lang, err := normalizeLang(l.Lang)
+		if err != nil {
+			invalid = append(invalid, InvalidParam{Name: name(".lang"), Reason: "must be a language code like pl or zh_cn"})
+		}
+		q.Lang = lang
+
+		location := strings.ToLower(q.Location())
+		if seen[location] {
+			invalid = append(invalid, InvalidParam{Name: name(""), Reason: "duplicate location"})
+		}
+		seen[location] = true
+		queries = append(queries, q)
+	}
+
+	return queries, invalid
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// renderCacheable writes body with validators, so clients and proxies can
+// reuse it. It responds with 304 if request validators match. ETag is
+// computed from version, as body may change without the content changing.
+func renderCacheable(w http.ResponseWriter, r *http.Request, body, version []byte, contentType string, lastModified, expiresAt time.Time) {
+	sum := sha256.Sum256(version)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	header := w.Header()
+	header.Set("ETag", etag)
+	header.Set("Vary", "Accept")
+	if !lastModified.IsZero() {
+		header.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if !expiresAt.IsZero() {
+		maxAge := int64(time.Until(expiresAt).Seconds())
+		if maxAge < 0 {
+			maxAge = 0
+		}
+		header.Set("Cache-Control", fmt.Sprintf("max-age=%d", maxAge))
+	}
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Type", contentType)
+	w.Write(body)
+}
+
+// notModified checks If-None-Match, or If-Modified-Since if the former is missing
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		return err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+	return false
+}
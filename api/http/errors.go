@@ -1,25 +1,161 @@
 package http
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
+	"github.com/papisz/weather"
 )
 
+// ErrorCode is a stable, machine-readable identifier of an error
+type ErrorCode string
+
+const (
+	CodeCityNotFound        ErrorCode = "CITY_NOT_FOUND"
+	CodeInvalidInput        ErrorCode = "INVALID_INPUT"
+	CodeNotAcceptable       ErrorCode = "NOT_ACCEPTABLE"
+	CodeUnauthorized        ErrorCode = "UNAUTHORIZED"
+	CodeUpstreamRateLimited ErrorCode = "UPSTREAM_RATE_LIMITED"
+	CodeUpstreamAuth        ErrorCode = "UPSTREAM_AUTH"
+	CodeUpstreamUnavailable ErrorCode = "UPSTREAM_UNAVAILABLE"
+	CodeUpstreamTimeout     ErrorCode = "UPSTREAM_TIMEOUT"
+	CodeInternal            ErrorCode = "INTERNAL"
+)
+
+// defaultRetryAfter is sent with rate limit errors if we don't know when the limit resets
+const defaultRetryAfter = time.Minute
+
+const problemContentType = "application/problem+json"
+
+// ErrResponse is an RFC 7807 problem details response
 type ErrResponse struct {
-	Err            error `json:"-"` // low-level runtime error
-	HTTPStatusCode int   `json:"-"` // http response status code
+	Err            error         `json:"-"`      // low-level runtime error
+	HTTPStatusCode int           `json:"status"` // http response status code
+	RetryAfter     time.Duration `json:"-"`      // sent in Retry-After header if positive
+
+	Type          string         `json:"type"`                     // URI identifying the problem type
+	StatusText    string         `json:"title"`                    // user-level status message
+	Code          ErrorCode      `json:"code"`                     // application-specific error code
+	ErrorText     string         `json:"detail,omitempty"`         // application-level error message, for debugging
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"` // list of invalid inputs
+}
 
-	StatusText string `json:"status"`          // user-level status message
-	AppCode    int64  `json:"code,omitempty"`  // application-specific error code
-	ErrorText  string `json:"error,omitempty"` // application-level error message, for debugging
+// InvalidParam describes why a single input was rejected
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
 }
 
 func (e *ErrResponse) Render(w http.ResponseWriter, r *http.Request) error {
 	reqID := middleware.GetReqID(r.Context())
 	render.Status(r, e.HTTPStatusCode)
+	if e.Code == "" {
+		e.Code = defaultCode(e.HTTPStatusCode)
+	}
+	e.Type = "urn:weather:error:" + strings.ToLower(strings.Replace(string(e.Code), "_", "-", -1))
+	if e.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int((e.RetryAfter+time.Second-1)/time.Second)))
+	}
 	log.Printf("error for %s: %v", reqID, e.Err)
 	return nil
 }
+
+// renderError writes e as application/problem+json
+func renderError(w http.ResponseWriter, r *http.Request, e *ErrResponse) {
+	e.Render(w, r)
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(true)
+	if err := enc.Encode(e); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(e.HTTPStatusCode)
+	w.Write(buf.Bytes())
+}
+
+func defaultCode(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeInvalidInput
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusNotFound:
+		return CodeCityNotFound
+	case http.StatusNotAcceptable:
+		return CodeNotAcceptable
+	}
+	return CodeInternal
+}
+
+// renderManagerError responds with status matching error returned by forecast manager
+func renderManagerError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, weather.ErrForecastNotFound):
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusNotFound,
+			StatusText:     weather.ErrForecastNotFound.Error(),
+			Code:           CodeCityNotFound,
+		})
+	case errors.Is(err, weather.ErrUnsupportedQuery):
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     weather.ErrUnsupportedQuery.Error(),
+			Code:           CodeInvalidInput,
+		})
+	case errors.Is(err, weather.ErrMisconfigured):
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadGateway,
+			StatusText:     weather.ErrMisconfigured.Error(),
+			Code:           CodeUpstreamAuth,
+		})
+	case errors.Is(err, weather.ErrTooManyRequests):
+		retryAfter := defaultRetryAfter
+		var retryErr *weather.RetryAfterError
+		if errors.As(err, &retryErr) {
+			retryAfter = retryErr.RetryAfter
+		}
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusTooManyRequests,
+			StatusText:     weather.ErrTooManyRequests.Error(),
+			Code:           CodeUpstreamRateLimited,
+			RetryAfter:     retryAfter,
+		})
+	case errors.Is(err, weather.ErrUnavailable):
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusServiceUnavailable,
+			StatusText:     weather.ErrUnavailable.Error(),
+			Code:           CodeUpstreamUnavailable,
+		})
+	case errors.Is(err, weather.ErrTimeout):
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusGatewayTimeout,
+			StatusText:     weather.ErrTimeout.Error(),
+			Code:           CodeUpstreamTimeout,
+		})
+	default:
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+			Code:           CodeInternal,
+		})
+	}
+}
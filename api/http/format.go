@@ -0,0 +1,282 @@
+package http
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/papisz/weather"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// errNotAcceptable means that client doesn't accept any of supported formats
+var errNotAcceptable = errors.New("not acceptable")
+
+// format encodes forecasts in one of supported media types
+type format struct {
+	name        string
+	contentType string
+	encode      func(forecasts *weather.Forecasts) ([]byte, error)
+}
+
+// formats are in order of preference, the first one is used by default
+var formats = []format{
+	{name: "json", contentType: "application/json", encode: encodeJSON},
+	{name: "xml", contentType: "application/xml", encode: encodeXML},
+	{name: "csv", contentType: "text/csv", encode: encodeCSV},
+	{name: "protobuf", contentType: "application/x-protobuf", encode: encodeProtobuf},
+}
+
+// negotiateFormat picks format from format query parameter, or from Accept header
+func negotiateFormat(r *http.Request) (format, error) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		for _, f := range formats {
+			if f.name == name {
+				return f, nil
+			}
+		}
+		return format{}, errNotAcceptable
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formats[0], nil
+	}
+
+	best, bestQ := -1, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		for i, f := range formats {
+			if !mediaTypeMatches(mediaType, f.contentType) {
+				continue
+			}
+			// prefer higher quality, then our order of preference
+			if q > bestQ || (q == bestQ && i < best) {
+				best, bestQ = i, q
+			}
+			break
+		}
+	}
+
+	if best < 0 {
+		return format{}, errNotAcceptable
+	}
+	return formats[best], nil
+}
+
+func mediaTypeMatches(pattern, contentType string) bool {
+	if pattern == "*/*" || pattern == contentType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(contentType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+func encodeJSON(forecasts *weather.Forecasts) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(true)
+	if err := enc.Encode(forecasts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type xmlForecasts struct {
+	XMLName xml.Name  `xml:"forecasts"`
+	Cities  []xmlCity `xml:"city"`
+}
+
+type xmlCity struct {
+	Query string `xml:"query,attr"`
+	*weather.Forecast
+	Freshness *weather.Freshness `xml:"freshness,omitempty"`
+}
+
+func encodeXML(forecasts *weather.Forecasts) ([]byte, error) {
+	doc := xmlForecasts{}
+	for _, city := range sortedCities(forecasts) {
+		doc.Cities = append(doc.Cities, xmlCity{
+			Query:     city,
+			Forecast:  forecasts.Cities[city],
+			Freshness: forecasts.Meta[city],
+		})
+	}
+
+	out, err := xml.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+var csvHeader = []string{
+	"city", "name", "country", "lon", "lat", "dt", "temp", "temp_min", "temp_max",
+	"pressure", "humidity", "wind_speed", "wind_deg", "clouds", "description",
+	"fetched_at", "age_seconds", "source", "cache_hit", "stale",
+}
+
+// encodeCSV writes one row per city
+func encodeCSV(forecasts *weather.Forecasts) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	w.Write(csvHeader)
+
+	for _, city := range sortedCities(forecasts) {
+		f := forecasts.Cities[city]
+		meta := freshnessColumns(forecasts.Meta[city])
+		w.Write(append([]string{
+			city,
+			f.Name,
+			f.Sys.Country,
+			formatFloat(f.Coord.Lon),
+			formatFloat(f.Coord.Lat),
+			strconv.Itoa(f.Dt),
+			formatFloat(f.Main.Temp),
+			formatFloat(f.Main.TempMin),
+			formatFloat(f.Main.TempMax),
+			strconv.Itoa(f.Main.Pressure),
+			strconv.Itoa(f.Main.Humidity),
+			formatFloat(f.Wind.Speed),
+			strconv.Itoa(f.Wind.Deg),
+			strconv.Itoa(f.Clouds.All),
+			description(f),
+		}, meta...))
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// encodeProtobuf encodes forecasts as Forecasts message described in forecast.proto
+func encodeProtobuf(forecasts *weather.Forecasts) ([]byte, error) {
+	var out []byte
+	for _, city := range sortedCities(forecasts) {
+		f := forecasts.Cities[city]
+
+		var msg []byte
+		msg = appendString(msg, 1, city)
+		msg = appendString(msg, 2, f.Name)
+		msg = appendString(msg, 3, f.Sys.Country)
+		msg = appendDouble(msg, 4, f.Coord.Lon)
+		msg = appendDouble(msg, 5, f.Coord.Lat)
+		msg = appendInt(msg, 6, int64(f.Dt))
+		msg = appendDouble(msg, 7, f.Main.Temp)
+		msg = appendDouble(msg, 8, f.Main.TempMin)
+		msg = appendDouble(msg, 9, f.Main.TempMax)
+		msg = appendInt(msg, 10, int64(f.Main.Pressure))
+		msg = appendInt(msg, 11, int64(f.Main.Humidity))
+		msg = appendDouble(msg, 12, f.Wind.Speed)
+		msg = appendInt(msg, 13, int64(f.Wind.Deg))
+		msg = appendInt(msg, 14, int64(f.Clouds.All))
+		msg = appendString(msg, 15, description(f))
+		if meta := forecasts.Meta[city]; meta != nil {
+			if !meta.FetchedAt.IsZero() {
+				msg = appendInt(msg, 16, meta.FetchedAt.Unix())
+			}
+			msg = appendInt(msg, 17, meta.AgeSeconds)
+			msg = appendString(msg, 18, meta.Source)
+			msg = appendBool(msg, 19, meta.CacheHit)
+			msg = appendBool(msg, 20, meta.Stale)
+		}
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, msg)
+	}
+	return out, nil
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(v))
+}
+
+// freshnessColumns returns CSV columns of metadata, empty if it's unknown
+func freshnessColumns(meta *weather.Freshness) []string {
+	if meta == nil {
+		return make([]string, 5)
+	}
+	fetchedAt := ""
+	if !meta.FetchedAt.IsZero() {
+		fetchedAt = meta.FetchedAt.UTC().Format(time.RFC3339)
+	}
+	return []string{
+		fetchedAt,
+		strconv.FormatInt(meta.AgeSeconds, 10),
+		meta.Source,
+		strconv.FormatBool(meta.CacheHit),
+		strconv.FormatBool(meta.Stale),
+	}
+}
+
+func sortedCities(forecasts *weather.Forecasts) []string {
+	cities := make([]string, 0, len(forecasts.Cities))
+	for city := range forecasts.Cities {
+		cities = append(cities, city)
+	}
+	sort.Strings(cities)
+	return cities
+}
+
+func description(f *weather.Forecast) string {
+	if len(f.Weather) == 0 {
+		return ""
+	}
+	return f.Weather[0].Description
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
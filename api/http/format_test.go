@@ -0,0 +1,109 @@
+package http
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/testutils"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestEncodeProtobuf(t *testing.T) {
+	forecasts := weather.NewForecasts()
+	forecasts.Cities["london"] = testutils.ForecastFromJSON("london.json")
+	forecasts.Meta["london"] = &weather.Freshness{
+		FetchedAt:  time.Date(2020, 4, 27, 19, 50, 0, 0, time.UTC),
+		AgeSeconds: 90,
+		Source:     "memory",
+		CacheHit:   true,
+		Stale:      true,
+	}
+	forecasts.Cities["berlin"] = &weather.Forecast{Name: "Berlin"}
+
+	out, err := encodeProtobuf(forecasts)
+	assert.Nil(t, err)
+
+	// cities are sorted, zero values are left out like proto3 does
+	assert.Equal(t, []map[protowire.Number]interface{}{
+		{
+			1: "berlin",
+			2: "Berlin",
+		},
+		{
+			1:  "london",
+			2:  "London",
+			3:  "GB",
+			4:  -0.13,
+			5:  51.51,
+			6:  int64(1588016537),
+			7:  287.71,
+			8:  285.37,
+			9:  289.82,
+			10: int64(1006),
+			11: int64(62),
+			12: 6.2,
+			13: int64(70),
+			14: int64(75),
+			15: "broken clouds",
+			16: int64(1588017000),
+			17: int64(90),
+			18: "memory",
+			19: true,
+			20: true,
+		},
+	}, decodeForecasts(t, out))
+}
+
+// decodeForecasts parses Forecasts message into fields of every city,
+// decoded with types given in forecast.proto
+func decodeForecasts(t *testing.T, b []byte) []map[protowire.Number]interface{} {
+	var cities []map[protowire.Number]interface{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if !assert.True(t, n > 0) || !assert.Equal(t, protowire.Number(1), num) || !assert.Equal(t, protowire.BytesType, typ) {
+			return nil
+		}
+		msg, m := protowire.ConsumeBytes(b[n:])
+		if !assert.True(t, m > 0) {
+			return nil
+		}
+		cities = append(cities, decodeCity(t, msg))
+		b = b[n+m:]
+	}
+	return cities
+}
+
+func decodeCity(t *testing.T, b []byte) map[protowire.Number]interface{} {
+	fields := map[protowire.Number]interface{}{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeField(b)
+		if !assert.True(t, n > 0, "invalid field") {
+			return fields
+		}
+		_, _, tagLen := protowire.ConsumeTag(b)
+		value := b[tagLen:n]
+		b = b[n:]
+
+		switch typ {
+		case protowire.BytesType:
+			v, _ := protowire.ConsumeString(value)
+			fields[num] = v
+		case protowire.Fixed64Type:
+			v, _ := protowire.ConsumeFixed64(value)
+			fields[num] = math.Float64frombits(v)
+		case protowire.VarintType:
+			v, _ := protowire.ConsumeVarint(value)
+			if num == 19 || num == 20 {
+				fields[num] = protowire.DecodeBool(v)
+			} else {
+				fields[num] = int64(v)
+			}
+		default:
+			t.Errorf("field %d has unexpected type %d", num, typ)
+		}
+	}
+	return fields
+}
@@ -2,24 +2,36 @@ package http
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/render"
 	"github.com/papisz/weather"
+	"github.com/papisz/weather/history"
 	"github.com/papisz/weather/weathersrc"
 )
 
 type HTTPApi struct {
 	ListenAddress  string
 	WeatherManager weathersrc.ForecastManager
+	HistoryStore   history.Store
+	Storage        weathersrc.WriteableForecastProvider
+	AdminToken     string
+	MaxBatchSize   int
 }
 
 type Option func(api *HTTPApi)
 
 func NewApi(opts ...Option) *HTTPApi {
-	api := &HTTPApi{}
+	api := &HTTPApi{
+		MaxBatchSize: defaultMaxBatchSize,
+	}
 
 	for _, opt := range opts {
 		opt(api)
@@ -39,6 +51,13 @@ func WithForecastManager(m weathersrc.ForecastManager) Option {
 	}
 }
 
+// WithHistoryStore enables /history endpoint
+func WithHistoryStore(store history.Store) Option {
+	return func(api *HTTPApi) {
+		api.HistoryStore = store
+	}
+}
+
 func (a *HTTPApi) GetForecasts(w http.ResponseWriter, r *http.Request) {
 	var err error
 	var forecasts = weather.NewForecasts()
@@ -52,8 +71,18 @@ func (a *HTTPApi) GetForecasts(w http.ResponseWriter, r *http.Request) {
 		return cities
 	}
 
+	f, err := negotiateFormat(r)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusNotAcceptable,
+			StatusText:     errNotAcceptable.Error(),
+		})
+		return
+	}
+
 	if cities = parseCities(r); cities == nil {
-		render.Render(w, r, &ErrResponse{
+		renderError(w, r, &ErrResponse{
 			Err:            nil,
 			HTTPStatusCode: http.StatusBadRequest,
 			StatusText:     "unable to parse cities",
@@ -61,46 +90,215 @@ func (a *HTTPApi) GetForecasts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if forecasts, err = a.WeatherManager.GetForecasts(cities...); err != nil {
-		switch errors.Unwrap(err) {
-		case weather.ErrForecastNotFound:
-			render.Render(w, r, &ErrResponse{
-				Err:            err,
-				HTTPStatusCode: http.StatusNotFound,
-				StatusText:     weather.ErrForecastNotFound.Error(),
-			})
-		case weather.ErrMisconfigured:
-			render.Render(w, r, &ErrResponse{
-				Err:            err,
-				HTTPStatusCode: http.StatusInternalServerError,
-				StatusText:     weather.ErrMisconfigured.Error(),
-			})
-		case weather.ErrTooManyRequests:
-			render.Render(w, r, &ErrResponse{
-				Err:            err,
-				HTTPStatusCode: http.StatusInternalServerError,
-				StatusText:     weather.ErrTooManyRequests.Error(),
-			})
-		default:
-			render.Render(w, r, &ErrResponse{
-				Err:            err,
-				HTTPStatusCode: http.StatusInternalServerError,
-				StatusText:     weather.ErrInternal.Error(),
-			})
-		}
+	cities, invalid := a.validateCities(cities)
+	if len(invalid) > 0 {
+		renderError(w, r, &ErrResponse{
+			Err:            nil,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "invalid cities",
+			InvalidParams:  invalid,
+		})
+		return
+	}
+
+	lang, err := parseLang(r)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "unable to parse lang",
+		})
+		return
+	}
+
+	maxAge, err := parseMaxAge(r)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "invalid max_age",
+			InvalidParams:  []InvalidParam{{Name: "max_age", Reason: err.Error()}},
+		})
+		return
+	}
+
+	refresh, errResp := a.parseRefresh(r)
+	if errResp != nil {
+		renderError(w, r, errResp)
+		return
+	}
+
+	queries := make([]weather.Query, 0, len(cities))
+	for _, city := range cities {
+		queries = append(queries, weather.Query{City: city, Lang: lang, MaxAge: maxAge, Refresh: refresh})
+	}
+
+	if forecasts, err = a.WeatherManager.QueryForecasts(queries...); err != nil {
+		renderManagerError(w, r, err)
 		return
 	}
 
-	render.JSON(w, r, forecasts)
+	body, err := f.encode(forecasts)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+		})
+		return
+	}
+
+	// freshness metadata changes with every request, the forecasts don't
+	version, err := f.encode(&weather.Forecasts{Cities: forecasts.Cities})
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+		})
+		return
+	}
+
+	renderCacheable(w, r, body, version, f.contentType, forecasts.LastModified(), forecasts.ExpiresAt)
 	return
 }
 
-func (a *HTTPApi) Serve() error {
+// GetHistory returns archived forecasts for one city. Time range is given in
+// from and to parameters, either as RFC 3339 or unix timestamps. By default it
+// covers everything up to now.
+func (a *HTTPApi) GetHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	city := query.Get("city")
+	if city == "" {
+		renderError(w, r, &ErrResponse{
+			Err:            nil,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "unable to parse city",
+		})
+		return
+	}
+
+	from, errFrom := parseTime(query.Get("from"), time.Unix(0, 0))
+	to, errTo := parseTime(query.Get("to"), time.Now())
+	if errFrom != nil || errTo != nil || to.Before(from) {
+		renderError(w, r, &ErrResponse{
+			Err:            nil,
+			HTTPStatusCode: http.StatusBadRequest,
+			StatusText:     "unable to parse time range",
+		})
+		return
+	}
+
+	forecasts, err := a.HistoryStore.Query(city, from, to)
+	if err != nil {
+		renderError(w, r, &ErrResponse{
+			Err:            err,
+			HTTPStatusCode: http.StatusInternalServerError,
+			StatusText:     weather.ErrInternal.Error(),
+		})
+		return
+	}
+
+	render.JSON(w, r, &weather.History{
+		City:      city,
+		Forecasts: forecasts,
+	})
+}
+
+var langPattern = regexp.MustCompile(`^[a-z]{2}(_[a-z]{2})?$`)
+
+// parseLang returns normalized language code from lang parameter
+func parseLang(r *http.Request) (string, error) {
+	return normalizeLang(r.URL.Query().Get("lang"))
+}
+
+// normalizeLang returns language code like "pl" or "zh_cn"
+func normalizeLang(lang string) (string, error) {
+	lang = strings.ToLower(strings.Replace(lang, "-", "_", 1))
+	if lang != "" && !langPattern.MatchString(lang) {
+		return "", fmt.Errorf("invalid language %q", lang)
+	}
+	return lang, nil
+}
+
+// parseRefresh tells if request asks to skip storage, with refresh parameter
+// or Cache-Control: no-cache. Refreshing costs upstream calls, so only admin
+// can force it. Browsers send no-cache on reload, so for others the header is
+// ignored, while the parameter is rejected.
+func (a *HTTPApi) parseRefresh(r *http.Request) (bool, *ErrResponse) {
+	refresh := false
+	if value := r.URL.Query().Get("refresh"); value != "" {
+		var err error
+		if refresh, err = strconv.ParseBool(value); err != nil {
+			return false, &ErrResponse{
+				Err:            err,
+				HTTPStatusCode: http.StatusBadRequest,
+				StatusText:     "invalid refresh",
+				InvalidParams:  []InvalidParam{{Name: "refresh", Reason: "must be true or false"}},
+			}
+		}
+		if refresh && !a.authorized(r) {
+			return false, &ErrResponse{
+				Err:            nil,
+				HTTPStatusCode: http.StatusUnauthorized,
+				StatusText:     "unauthorized",
+				ErrorText:      "refresh requires admin token",
+			}
+		}
+	}
+
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		if strings.EqualFold(strings.TrimSpace(directive), "no-cache") && a.authorized(r) {
+			refresh = true
+		}
+	}
+	return refresh, nil
+}
+
+// parseMaxAge returns max_age parameter given in seconds, zero if it's missing
+func parseMaxAge(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("max_age")
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 {
+		return 0, errors.New("must be a non-negative number of seconds")
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// parseTime parses RFC 3339 or unix timestamp, returning def for empty value
+func parseTime(value string, def time.Time) (time.Time, error) {
+	if value == "" {
+		return def, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// Router returns handler serving all API endpoints
+func (a *HTTPApi) Router() http.Handler {
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 	r.Use(middleware.RequestID)
 	r.Use(render.SetContentType(render.ContentTypeJSON))
 	r.Get("/forecast", a.GetForecasts)
-	return http.ListenAndServe(a.ListenAddress, r)
+	r.Post("/forecast", a.PostForecasts)
+	r.Get("/openapi.json", a.GetOpenAPI)
+	if a.HistoryStore != nil {
+		r.Get("/history", a.GetHistory)
+	}
+	if a.AdminToken != "" && a.Storage != nil {
+		r.Mount("/admin", a.adminRouter())
+	}
+	return r
+}
+
+func (a *HTTPApi) Serve() error {
+	return http.ListenAndServe(a.ListenAddress, a.Router())
 }
@@ -1,14 +1,18 @@
 package http
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/papisz/weather"
 
+	"github.com/papisz/weather/history/memory"
 	"github.com/papisz/weather/testutils"
 	"github.com/papisz/weather/weathersrc"
 	"github.com/papisz/weather/weathersrc/cache"
@@ -43,7 +47,10 @@ func TestWithFileIntegration(t *testing.T) {
 			},
 			expectedStatus: 404,
 			expectedBody: []byte(`{
-				"status": "forecast not found"
+				"type": "urn:weather:error:city-not-found",
+				"status": 404,
+				"title": "forecast not found",
+				"code": "CITY_NOT_FOUND"
 			}`),
 		},
 		{
@@ -53,7 +60,10 @@ func TestWithFileIntegration(t *testing.T) {
 			},
 			expectedStatus: 400,
 			expectedBody: []byte(`{
-				"status": "unable to parse cities"
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "unable to parse cities",
+				"code": "INVALID_INPUT"
 			}`),
 		},
 	}
@@ -71,6 +81,9 @@ func TestWithFileIntegration(t *testing.T) {
 					weathersrc.WithStorageProvider(
 						cache.NewWeatherSrc(cache.WithTTL(5*time.Second)),
 					),
+					weathersrc.WithClock(func() time.Time {
+						return time.Date(2020, 4, 27, 19, 50, 0, 0, time.UTC)
+					}),
 				)),
 			)
 			w := httptest.NewRecorder()
@@ -91,10 +104,11 @@ func TestHTTPApi_GetForecastsErrors(t *testing.T) {
 	}
 
 	tests := []struct {
-		name           string
-		fields         fields
-		expectedStatus int
-		expectedBody   []byte
+		name               string
+		fields             fields
+		expectedStatus     int
+		expectedRetryAfter string
+		expectedBody       []byte
 	}{
 		{
 			name: "Get forecasts returning misconfigured service",
@@ -103,9 +117,27 @@ func TestHTTPApi_GetForecastsErrors(t *testing.T) {
 					err: fmt.Errorf("%w", weather.ErrMisconfigured),
 				},
 			},
-			expectedStatus: 500,
+			expectedStatus: 502,
+			expectedBody: []byte(`{
+				"type": "urn:weather:error:upstream-auth",
+				"status": 502,
+				"title": "misconfigured service",
+				"code": "UPSTREAM_AUTH"
+			}`),
+		},
+		{
+			name: "Get forecasts by id from provider supporting only city names",
+			fields: fields{
+				weatherManager: &forecastManagerMock{
+					err: fmt.Errorf("%w by file provider: id or coordinates", weather.ErrUnsupportedQuery),
+				},
+			},
+			expectedStatus: 400,
 			expectedBody: []byte(`{
-				"status": "misconfigured service"
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "query not supported",
+				"code": "INVALID_INPUT"
 			}`),
 		},
 		{
@@ -115,9 +147,59 @@ func TestHTTPApi_GetForecastsErrors(t *testing.T) {
 					err: fmt.Errorf("%w", weather.ErrTooManyRequests),
 				},
 			},
-			expectedStatus: 500,
+			expectedStatus:     429,
+			expectedRetryAfter: "60",
+			expectedBody: []byte(`{
+				"type": "urn:weather:error:upstream-rate-limited",
+				"status": 429,
+				"title": "too many requests",
+				"code": "UPSTREAM_RATE_LIMITED"
+			}`),
+		},
+		{
+			name: "Get forecasts with too many requests and known reset time",
+			fields: fields{
+				weatherManager: &forecastManagerMock{
+					err: fmt.Errorf("%w", &weather.RetryAfterError{Err: weather.ErrTooManyRequests, RetryAfter: 1500 * time.Millisecond}),
+				},
+			},
+			expectedStatus:     429,
+			expectedRetryAfter: "2",
+			expectedBody: []byte(`{
+				"type": "urn:weather:error:upstream-rate-limited",
+				"status": 429,
+				"title": "too many requests",
+				"code": "UPSTREAM_RATE_LIMITED"
+			}`),
+		},
+		{
+			name: "Get forecasts with external service unavailable",
+			fields: fields{
+				weatherManager: &forecastManagerMock{
+					err: fmt.Errorf("%w: connection refused", weather.ErrUnavailable),
+				},
+			},
+			expectedStatus: 503,
 			expectedBody: []byte(`{
-				"status": "too many requests"
+				"type": "urn:weather:error:upstream-unavailable",
+				"status": 503,
+				"title": "external service unavailable",
+				"code": "UPSTREAM_UNAVAILABLE"
+			}`),
+		},
+		{
+			name: "Get forecasts with external service timeout",
+			fields: fields{
+				weatherManager: &forecastManagerMock{
+					err: fmt.Errorf("%w: deadline exceeded", weather.ErrTimeout),
+				},
+			},
+			expectedStatus: 504,
+			expectedBody: []byte(`{
+				"type": "urn:weather:error:upstream-timeout",
+				"status": 504,
+				"title": "external service timeout",
+				"code": "UPSTREAM_TIMEOUT"
 			}`),
 		},
 		{
@@ -130,7 +212,10 @@ func TestHTTPApi_GetForecastsErrors(t *testing.T) {
 			},
 			expectedStatus: 500,
 			expectedBody: []byte(`{
-				"status": "internal error"
+				"type": "urn:weather:error:internal",
+				"status": 500,
+				"title": "internal error",
+				"code": "INTERNAL"
 			}`),
 		},
 	}
@@ -145,12 +230,632 @@ func TestHTTPApi_GetForecastsErrors(t *testing.T) {
 			a.GetForecasts(w, r.newRequest("forecast?city=london&city=szczebrzeszyn"))
 
 			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+			assert.Equal(t, tt.expectedRetryAfter, w.Header().Get("Retry-After"))
 			assert.JSONEq(t, string(tt.expectedBody), w.Body.String())
 		})
 
 	}
 }
 
+func TestHTTPApi_GetHistory(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+
+	store := memory.NewStore()
+	london := testutils.ForecastFromJSON("london.json")
+	store.Append("london", london)
+
+	tests := []struct {
+		name           string
+		url            string
+		expectedStatus int
+		expectedBody   *weather.History
+	}{
+		{
+			name:           "Whole history",
+			url:            "history?city=London",
+			expectedStatus: 200,
+			expectedBody:   &weather.History{City: "London", Forecasts: []*weather.Forecast{london}},
+		},
+		{
+			name:           "Time range with RFC 3339 and unix timestamps",
+			url:            fmt.Sprintf("history?city=london&from=%s&to=%d", time.Unix(int64(london.Dt), 0).Format(time.RFC3339), london.Dt),
+			expectedStatus: 200,
+			expectedBody:   &weather.History{City: "london", Forecasts: []*weather.Forecast{london}},
+		},
+		{
+			name:           "Time range without forecasts",
+			url:            fmt.Sprintf("history?city=london&to=%d", london.Dt-1),
+			expectedStatus: 200,
+			expectedBody:   &weather.History{City: "london", Forecasts: []*weather.Forecast{}},
+		},
+		{
+			name:           "Error: no city given",
+			url:            "history",
+			expectedStatus: 400,
+		},
+		{
+			name:           "Error: invalid time range",
+			url:            "history?city=london&from=yesterday",
+			expectedStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewApi(
+				WithListenAddress(r.listenAddress),
+				WithHistoryStore(store),
+			)
+			w := httptest.NewRecorder()
+			a.GetHistory(w, r.newRequest(tt.url))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != nil {
+				expectedBody, _ := json.Marshal(tt.expectedBody)
+				assert.JSONEq(t, string(expectedBody), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHTTPApi_Admin(t *testing.T) {
+	storage := cache.NewWeatherSrc(cache.WithTTL(time.Hour))
+	storage.SaveForecast("london", testutils.ForecastFromJSON("london.json"))
+	storage.SaveForecast("warsaw", testutils.ForecastFromJSON("warsaw.json"))
+
+	a := NewApi(WithAdmin("secret", storage))
+	server := httptest.NewServer(a.Router())
+	defer server.Close()
+
+	do := func(method, path, token string) *http.Response {
+		req, _ := http.NewRequest(method, server.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		return resp
+	}
+
+	assert.Equal(t, 401, do("GET", "/admin/cache", "").StatusCode)
+	assert.Equal(t, 401, do("DELETE", "/admin/cache", "wrong").StatusCode)
+
+	resp := do("GET", "/admin/cache", "secret")
+	assert.Equal(t, 200, resp.StatusCode)
+	var entries CacheEntries
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&entries))
+	assert.Len(t, entries.Entries, 2)
+	assert.Equal(t, "warsaw", entries.Entries[0].City)
+	assert.Equal(t, "memory", entries.Entries[0].Source)
+	assert.InDelta(t, 3600, *entries.Entries[0].TTLRemainingSeconds, 1)
+
+	assert.Equal(t, 204, do("DELETE", "/admin/cache/london", "secret").StatusCode)
+	assert.Equal(t, 404, do("DELETE", "/admin/cache/london", "secret").StatusCode)
+	_, err := storage.GetForecast("london")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+
+	// cities are matched regardless of case, like in forecast requests
+	assert.Nil(t, storage.SaveForecast("London", testutils.ForecastFromJSON("london.json")))
+	assert.Equal(t, 204, do("DELETE", "/admin/cache/LONDON", "secret").StatusCode)
+	_, err = storage.GetForecast("london")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+
+	// forecasts in other units and languages are evicted with the city,
+	// cities only starting with its name are kept
+	for _, key := range []string{"London?lang=pl", "London?lang=pl&units=metric", "London?units=metric", "Londonderry"} {
+		assert.Nil(t, storage.SaveForecast(key, testutils.ForecastFromJSON("london.json")))
+	}
+	assert.Equal(t, 204, do("DELETE", "/admin/cache/london", "secret").StatusCode)
+	stored, err := storage.ListForecasts()
+	assert.Nil(t, err)
+	cities := []string{}
+	for _, e := range stored {
+		cities = append(cities, e.City)
+	}
+	assert.ElementsMatch(t, []string{"warsaw", "Londonderry"}, cities)
+
+	assert.Equal(t, 204, do("DELETE", "/admin/cache", "secret").StatusCode)
+	_, err = storage.GetForecast("warsaw")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+}
+
+func TestHTTPApi_AdminDisabledWithoutToken(t *testing.T) {
+	a := NewApi(WithAdmin("", cache.NewWeatherSrc()))
+	w := httptest.NewRecorder()
+	a.Router().ServeHTTP(w, httptest.NewRequest("GET", "/admin/cache", nil))
+
+	assert.Equal(t, 404, w.Code)
+}
+
+func TestHTTPApi_CachingHeaders(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+	a := NewApi(
+		WithListenAddress(r.listenAddress),
+		WithForecastManager(weathersrc.NewForecastManager(
+			weathersrc.WithExternalProvider(
+				file.NewWeatherSrc(
+					file.WithDirPath("../../testdata/source"),
+				),
+			),
+			weathersrc.WithStorageProvider(
+				cache.NewWeatherSrc(cache.WithTTL(time.Hour)),
+			),
+		)),
+	)
+
+	w := httptest.NewRecorder()
+	a.GetForecasts(w, r.newRequest("forecast?city=london&city=warsaw"))
+
+	assert.Equal(t, 200, w.Code)
+	etag := w.Header().Get("ETag")
+	lastModified := w.Header().Get("Last-Modified")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, "Mon, 27 Apr 2020 19:46:46 GMT", lastModified)
+	assert.Regexp(t, `^max-age=(3599|3600)$`, w.Header().Get("Cache-Control"))
+
+	tests := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{
+			name:           "Matching ETag",
+			header:         "If-None-Match",
+			value:          `"other", ` + etag,
+			expectedStatus: 304,
+		},
+		{
+			name:           "Different ETag",
+			header:         "If-None-Match",
+			value:          `"other"`,
+			expectedStatus: 200,
+		},
+		{
+			name:           "Not modified since",
+			header:         "If-Modified-Since",
+			value:          lastModified,
+			expectedStatus: 304,
+		},
+		{
+			name:           "Modified since",
+			header:         "If-Modified-Since",
+			value:          "Mon, 27 Apr 2020 19:00:00 GMT",
+			expectedStatus: 200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := r.newRequest("forecast?city=london&city=warsaw")
+			req.Header.Set(tt.header, tt.value)
+			w := httptest.NewRecorder()
+			a.GetForecasts(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, etag, w.Header().Get("ETag"))
+			if tt.expectedStatus == 304 {
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRenderCacheable_WithoutLastModified(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+	req := r.newRequest("forecast?city=london")
+	req.Header.Set("If-Modified-Since", "Mon, 27 Apr 2020 19:00:00 GMT")
+	w := httptest.NewRecorder()
+
+	renderCacheable(w, req, []byte("{}"), []byte("{}"), "application/json", weather.NewForecasts().LastModified(), time.Time{})
+
+	assert.Equal(t, 200, w.Code)
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+	assert.Empty(t, w.Header().Get("Cache-Control"))
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.Equal(t, "{}", w.Body.String())
+}
+
+func TestHTTPApi_ContentNegotiation(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+	forecasts := weather.NewForecasts()
+	forecasts.Cities["warsaw"] = testutils.ForecastFromJSON("warsaw.json")
+	forecasts.Cities["london"] = testutils.ForecastFromJSON("london.json")
+	forecasts.Meta["london"] = &weather.Freshness{
+		FetchedAt:  time.Date(2020, 4, 27, 19, 45, 0, 0, time.UTC),
+		AgeSeconds: 120,
+		Source:     "memory",
+		CacheHit:   true,
+	}
+
+	tests := []struct {
+		name                string
+		url                 string
+		accept              string
+		expectedStatus      int
+		expectedContentType string
+		expectedBodyPrefix  string
+	}{
+		{
+			name:                "Default is JSON",
+			url:                 "forecast?city=london",
+			expectedStatus:      200,
+			expectedContentType: "application/json",
+			expectedBodyPrefix:  `{"cities":`,
+		},
+		{
+			name:                "Any type",
+			url:                 "forecast?city=london",
+			accept:              "*/*",
+			expectedStatus:      200,
+			expectedContentType: "application/json",
+		},
+		{
+			name:                "XML preferred by quality",
+			url:                 "forecast?city=london",
+			accept:              "application/json;q=0.5, application/xml",
+			expectedStatus:      200,
+			expectedContentType: "application/xml",
+			expectedBodyPrefix:  xml.Header + `<forecasts><city query="london"><coord><lon>-0.13</lon>`,
+		},
+		{
+			name:                "CSV by wildcard",
+			url:                 "forecast?city=london",
+			accept:              "text/*",
+			expectedStatus:      200,
+			expectedContentType: "text/csv",
+			expectedBodyPrefix: "city,name,country,lon,lat,dt,temp,temp_min,temp_max,pressure,humidity,wind_speed,wind_deg,clouds,description,fetched_at,age_seconds,source,cache_hit,stale\n" +
+				"london,London,GB,-0.13,51.51,1588016537,287.71,285.37,289.82,1006,62,6.2,70,75,broken clouds,2020-04-27T19:45:00Z,120,memory,true,false\n" +
+				"warsaw,",
+		},
+		{
+			name:                "Protobuf by format parameter overrides Accept",
+			url:                 "forecast?city=london&format=protobuf",
+			accept:              "application/json",
+			expectedStatus:      200,
+			expectedContentType: "application/x-protobuf",
+			expectedBodyPrefix:  "\n",
+		},
+		{
+			name:           "Error: unsupported format parameter",
+			url:            "forecast?city=london&format=yaml",
+			expectedStatus: 406,
+		},
+		{
+			name:           "Error: unsupported Accept",
+			url:            "forecast?city=london",
+			accept:         "text/html, application/json;q=0",
+			expectedStatus: 406,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewApi(
+				WithListenAddress(r.listenAddress),
+				WithForecastManager(&forecastManagerMock{forecasts: forecasts}),
+			)
+			req := r.newRequest(tt.url)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			a.GetForecasts(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedContentType != "" {
+				assert.Equal(t, tt.expectedContentType, w.Header().Get("Content-Type"))
+			}
+			assert.True(t, strings.HasPrefix(w.Body.String(), tt.expectedBodyPrefix), w.Body.String())
+		})
+	}
+}
+
+func TestHTTPApi_GetForecastsOptions(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+
+	tests := []struct {
+		name            string
+		url             string
+		expectedStatus  int
+		expectedQueries []weather.Query
+	}{
+		{
+			name:            "Without lang",
+			url:             "forecast?city=london",
+			expectedStatus:  200,
+			expectedQueries: []weather.Query{{City: "london"}},
+		},
+		{
+			name:            "Lang is normalized and applied to every city",
+			url:             "forecast?city=london&city=warsaw&lang=zh-CN",
+			expectedStatus:  200,
+			expectedQueries: []weather.Query{{City: "london", Lang: "zh_cn"}, {City: "warsaw", Lang: "zh_cn"}},
+		},
+		{
+			name:           "Error: invalid lang",
+			url:            "forecast?city=london&lang=polish",
+			expectedStatus: 400,
+		},
+		{
+			name:            "Max age is applied to every city",
+			url:             "forecast?city=london&city=warsaw&max_age=300",
+			expectedStatus:  200,
+			expectedQueries: []weather.Query{{City: "london", MaxAge: 5 * time.Minute}, {City: "warsaw", MaxAge: 5 * time.Minute}},
+		},
+		{
+			name:           "Error: negative max age",
+			url:            "forecast?city=london&max_age=-1",
+			expectedStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &forecastManagerMock{forecasts: weather.NewForecasts()}
+			a := NewApi(
+				WithListenAddress(r.listenAddress),
+				WithForecastManager(m),
+			)
+			w := httptest.NewRecorder()
+			a.GetForecasts(w, r.newRequest(tt.url))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedQueries, m.queries)
+		})
+	}
+}
+
+func TestHTTPApi_Refresh(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+
+	tests := []struct {
+		name            string
+		url             string
+		token           string
+		cacheControl    string
+		expectedStatus  int
+		expectedRefresh bool
+	}{
+		{
+			name:           "Without refresh",
+			url:            "forecast?city=london",
+			token:          "secret",
+			expectedStatus: 200,
+		},
+		{
+			name:            "Refresh parameter with admin token",
+			url:             "forecast?city=london&refresh=true",
+			token:           "secret",
+			expectedStatus:  200,
+			expectedRefresh: true,
+		},
+		{
+			name:            "No-cache with admin token",
+			url:             "forecast?city=london",
+			token:           "secret",
+			cacheControl:    "max-age=0, no-cache",
+			expectedStatus:  200,
+			expectedRefresh: true,
+		},
+		{
+			name:           "No-cache without admin token is ignored",
+			url:            "forecast?city=london",
+			cacheControl:   "no-cache",
+			expectedStatus: 200,
+		},
+		{
+			name:           "Error: refresh parameter without admin token",
+			url:            "forecast?city=london&refresh=true",
+			token:          "wrong",
+			expectedStatus: 401,
+		},
+		{
+			name:           "Error: invalid refresh parameter",
+			url:            "forecast?city=london&refresh=always",
+			token:          "secret",
+			expectedStatus: 400,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &forecastManagerMock{forecasts: weather.NewForecasts()}
+			a := NewApi(
+				WithListenAddress(r.listenAddress),
+				WithForecastManager(m),
+				WithAdmin("secret", nil),
+			)
+			req := r.newRequest(tt.url)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.cacheControl != "" {
+				req.Header.Set("Cache-Control", tt.cacheControl)
+			}
+			w := httptest.NewRecorder()
+			a.GetForecasts(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedStatus == 200 {
+				assert.Equal(t, []weather.Query{{City: "london", Refresh: tt.expectedRefresh}}, m.queries)
+			} else {
+				assert.Nil(t, m.queries)
+			}
+		})
+	}
+}
+
+func TestHTTPApi_PostForecasts(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+
+	tests := []struct {
+		name            string
+		body            string
+		expectedStatus  int
+		expectedBody    string
+		expectedQueries []weather.Query
+	}{
+		{
+			name: "Locations by name, id and coordinates",
+			body: `{"locations": [
+				{"name": "london", "units": "metric", "lang": "PL"},
+				{"id": 756135},
+				{"coord": {"lat": 51.51, "lon": -0.13}}
+			]}`,
+			expectedStatus: 200,
+			expectedQueries: []weather.Query{
+				{City: "london", Units: "metric", Lang: "pl"},
+				{ID: 756135},
+				{Coord: &weather.Coord{Lat: 51.51, Lon: -0.13}},
+			},
+		},
+		{
+			name:           "Error: malformed body",
+			body:           `{"locations": [{"city": "london"}]}`,
+			expectedStatus: 400,
+			expectedBody: `{
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "unable to parse request body",
+				"code": "INVALID_INPUT",
+				"detail": "json: unknown field \"city\""
+			}`,
+		},
+		{
+			name:           "Error: no locations",
+			body:           `{"locations": []}`,
+			expectedStatus: 400,
+			expectedBody: `{
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "invalid locations",
+				"code": "INVALID_INPUT",
+				"invalid_params": [{"name": "locations", "reason": "at least one location is required"}]
+			}`,
+		},
+		{
+			name:           "Error: too many locations",
+			body:           `{"locations": [{"name": "a"}, {"name": "b"}, {"name": "c"}, {"name": "d"}]}`,
+			expectedStatus: 400,
+			expectedBody: `{
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "invalid locations",
+				"code": "INVALID_INPUT",
+				"invalid_params": [{"name": "locations", "reason": "at most 3 locations are allowed"}]
+			}`,
+		},
+		{
+			name: "Error: every invalid location is listed",
+			body: `{"locations": [
+				{"name": "london", "id": 1},
+				{"coord": {"lat": 91, "lon": 0}, "units": "kelvin"},
+				{"name": "london", "lang": "polish"}
+			]}`,
+			expectedStatus: 400,
+			expectedBody: `{
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "invalid locations",
+				"code": "INVALID_INPUT",
+				"invalid_params": [
+					{"name": "locations[0]", "reason": "exactly one of name, id or coord is required"},
+					{"name": "locations[1].coord.lat", "reason": "must be between -90 and 90"},
+					{"name": "locations[1].units", "reason": "must be one of standard, metric or imperial"},
+					{"name": "locations[2].lang", "reason": "must be a language code like pl or zh_cn"}
+				]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &forecastManagerMock{forecasts: weather.NewForecasts()}
+			a := NewApi(
+				WithListenAddress(r.listenAddress),
+				WithForecastManager(m),
+				WithMaxBatchSize(3),
+			)
+			req, _ := http.NewRequest("POST", r.listenAddress+"/forecast", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			a.PostForecasts(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+			assert.Equal(t, tt.expectedQueries, m.queries)
+		})
+	}
+}
+
+func TestHTTPApi_GetForecastsValidation(t *testing.T) {
+	r := requestCreator{listenAddress: "localhost:5555"}
+
+	tests := []struct {
+		name            string
+		url             string
+		expectedStatus  int
+		expectedBody    string
+		expectedQueries []weather.Query
+	}{
+		{
+			name:            "Cities are normalized and deduplicated",
+			url:             "forecast?city=%20New%20%20York&city=london&city=new+york&city=LONDON",
+			expectedStatus:  200,
+			expectedQueries: []weather.Query{{City: "New York"}, {City: "london"}},
+		},
+		{
+			name:           "Error: every invalid city is listed",
+			url:            "forecast?city=london&city=&city=lon%00don&city=" + strings.Repeat("a", 101),
+			expectedStatus: 400,
+			expectedBody: `{
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "invalid cities",
+				"code": "INVALID_INPUT",
+				"invalid_params": [
+					{"name": "city[1]", "reason": "must not be empty"},
+					{"name": "city[2]", "reason": "must not contain control characters"},
+					{"name": "city[3]", "reason": "must be at most 100 characters long"}
+				]
+			}`,
+		},
+		{
+			name:           "Error: too many cities",
+			url:            "forecast?city=a&city=b&city=c&city=d&city=e",
+			expectedStatus: 400,
+			expectedBody: `{
+				"type": "urn:weather:error:invalid-input",
+				"status": 400,
+				"title": "invalid cities",
+				"code": "INVALID_INPUT",
+				"invalid_params": [{"name": "city", "reason": "at most 4 cities are allowed"}]
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &forecastManagerMock{forecasts: weather.NewForecasts()}
+			a := NewApi(
+				WithListenAddress(r.listenAddress),
+				WithForecastManager(m),
+				WithMaxBatchSize(4),
+			)
+			w := httptest.NewRecorder()
+			a.GetForecasts(w, r.newRequest(tt.url))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+			assert.Equal(t, tt.expectedQueries, m.queries)
+		})
+	}
+}
+
 type requestCreator struct {
 	listenAddress string
 }
@@ -163,8 +868,14 @@ func (r *requestCreator) newRequest(url string) *http.Request {
 type forecastManagerMock struct {
 	err       error
 	forecasts *weather.Forecasts
+	queries   []weather.Query
 }
 
 func (m *forecastManagerMock) GetForecasts(cities ...string) (*weather.Forecasts, error) {
 	return m.forecasts, m.err
 }
+
+func (m *forecastManagerMock) QueryForecasts(queries ...weather.Query) (*weather.Forecasts, error) {
+	m.queries = queries
+	return m.forecasts, m.err
+}
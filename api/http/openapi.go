@@ -0,0 +1,396 @@
+package http
+
+import (
+	"net/http"
+)
+
+// GetOpenAPI serves OpenAPI specification of this API
+func (a *HTTPApi) GetOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(openAPISpec))
+}
+
+// openAPISpec describes all routes. Keep it in sync with handlers, openapi_test.go
+// validates their responses against it.
+const openAPISpec = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "Weather",
+    "description": "Current weather for cities, backed by OpenWeather.",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/forecast": {
+      "get": {
+        "summary": "Get forecasts for cities",
+        "security": [{}, {"AdminToken": []}],
+        "parameters": [
+          {
+            "name": "city",
+            "in": "query",
+            "required": true,
+            "description": "City name, can be repeated. Names are normalized and deduplicated.",
+            "schema": {"type": "array", "items": {"type": "string", "minLength": 1, "maxLength": 100}},
+            "style": "form",
+            "explode": true
+          },
+          {"$ref": "#/components/parameters/Lang"},
+          {"$ref": "#/components/parameters/MaxAge"},
+          {"$ref": "#/components/parameters/Refresh"},
+          {"$ref": "#/components/parameters/CacheControl"},
+          {"$ref": "#/components/parameters/Format"}
+        ],
+        "responses": {
+          "200": {"$ref": "#/components/responses/Forecasts"},
+          "304": {"description": "Forecasts didn't change since the version identified by If-None-Match or If-Modified-Since"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "406": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"},
+          "502": {"$ref": "#/components/responses/Problem"},
+          "503": {"$ref": "#/components/responses/Problem"},
+          "504": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "post": {
+        "summary": "Get forecasts for a batch of locations",
+        "security": [{}, {"AdminToken": []}],
+        "parameters": [
+          {"$ref": "#/components/parameters/MaxAge"},
+          {"$ref": "#/components/parameters/Refresh"},
+          {"$ref": "#/components/parameters/CacheControl"},
+          {"$ref": "#/components/parameters/Format"}
+        ],
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {"schema": {"$ref": "#/components/schemas/BatchRequest"}}
+          }
+        },
+        "responses": {
+          "200": {"$ref": "#/components/responses/Forecasts"},
+          "400": {"$ref": "#/components/responses/Problem"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "406": {"$ref": "#/components/responses/Problem"},
+          "429": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"},
+          "502": {"$ref": "#/components/responses/Problem"},
+          "503": {"$ref": "#/components/responses/Problem"},
+          "504": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/history": {
+      "get": {
+        "summary": "Get archived forecasts for a city",
+        "parameters": [
+          {"name": "city", "in": "query", "required": true, "schema": {"type": "string"}},
+          {"name": "from", "in": "query", "description": "RFC 3339 or unix timestamp, beginning of time by default", "schema": {"type": "string"}},
+          {"name": "to", "in": "query", "description": "RFC 3339 or unix timestamp, now by default", "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Forecasts ordered by dt",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/History"}}}
+          },
+          "400": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/admin/cache": {
+      "get": {
+        "summary": "List stored forecasts",
+        "security": [{"AdminToken": []}],
+        "responses": {
+          "200": {
+            "description": "Stored forecasts",
+            "content": {"application/json": {"schema": {"$ref": "#/components/schemas/CacheEntries"}}}
+          },
+          "401": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      },
+      "delete": {
+        "summary": "Remove all stored forecasts",
+        "security": [{"AdminToken": []}],
+        "responses": {
+          "204": {"description": "Storage flushed"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/admin/cache/{city}": {
+      "delete": {
+        "summary": "Remove stored forecast for a city",
+        "security": [{"AdminToken": []}],
+        "parameters": [
+          {"name": "city", "in": "path", "required": true, "schema": {"type": "string"}}
+        ],
+        "responses": {
+          "204": {"description": "Forecast removed"},
+          "401": {"$ref": "#/components/responses/Problem"},
+          "404": {"$ref": "#/components/responses/Problem"},
+          "500": {"$ref": "#/components/responses/Problem"}
+        }
+      }
+    },
+    "/openapi.json": {
+      "get": {
+        "summary": "Get this specification",
+        "responses": {
+          "200": {"description": "OpenAPI specification", "content": {"application/json": {}}}
+        }
+      }
+    }
+  },
+  "components": {
+    "securitySchemes": {
+      "AdminToken": {"type": "http", "scheme": "bearer"}
+    },
+    "parameters": {
+      "Lang": {
+        "name": "lang",
+        "in": "query",
+        "description": "Language of weather descriptions, like pl or zh_cn",
+        "schema": {"type": "string", "pattern": "^[a-zA-Z]{2}([_-][a-zA-Z]{2})?$"}
+      },
+      "MaxAge": {
+        "name": "max_age",
+        "in": "query",
+        "description": "Seconds after which stored forecasts are fetched again. If that fails, the stored forecast is returned marked as stale.",
+        "schema": {"type": "integer", "minimum": 0}
+      },
+      "Refresh": {
+        "name": "refresh",
+        "in": "query",
+        "description": "Fetch forecasts from external provider instead of storage and store them. Requires admin token.",
+        "schema": {"type": "boolean"}
+      },
+      "CacheControl": {
+        "name": "Cache-Control",
+        "in": "header",
+        "description": "no-cache works like refresh=true with admin token and is ignored without it",
+        "schema": {"type": "string"}
+      },
+      "Format": {
+        "name": "format",
+        "in": "query",
+        "description": "Response format, overrides Accept header",
+        "schema": {"type": "string", "enum": ["json", "xml", "csv", "protobuf"]}
+      }
+    },
+    "responses": {
+      "Forecasts": {
+        "description": "Forecasts keyed by location",
+        "headers": {
+          "ETag": {"schema": {"type": "string"}},
+          "Last-Modified": {"schema": {"type": "string"}},
+          "Cache-Control": {"schema": {"type": "string"}}
+        },
+        "content": {
+          "application/json": {"schema": {"$ref": "#/components/schemas/Forecasts"}},
+          "application/xml": {},
+          "text/csv": {},
+          "application/x-protobuf": {}
+        }
+      },
+      "Problem": {
+        "description": "Error described by RFC 7807 problem details",
+        "headers": {
+          "Retry-After": {"description": "Seconds until request may succeed, sent with 429", "schema": {"type": "integer"}}
+        },
+        "content": {
+          "application/problem+json": {"schema": {"$ref": "#/components/schemas/Problem"}}
+        }
+      }
+    },
+    "schemas": {
+      "Forecasts": {
+        "type": "object",
+        "required": ["cities"],
+        "properties": {
+          "cities": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/Forecast"}},
+          "meta": {"type": "object", "additionalProperties": {"$ref": "#/components/schemas/Freshness"}}
+        }
+      },
+      "Freshness": {
+        "type": "object",
+        "description": "Where forecast came from and how old it is",
+        "required": ["fetched_at", "age_seconds", "source", "cache_hit", "stale"],
+        "additionalProperties": false,
+        "properties": {
+          "fetched_at": {"type": "string", "format": "date-time", "description": "When forecast was fetched from external provider"},
+          "age_seconds": {"type": "integer"},
+          "source": {"type": "string", "description": "External provider on cache miss, storage on cache hit"},
+          "cache_hit": {"type": "boolean"},
+          "stale": {"type": "boolean", "description": "Forecast is older than max_age, but couldn't be refreshed"}
+        }
+      },
+      "Forecast": {
+        "type": "object",
+        "additionalProperties": false,
+        "properties": {
+          "coord": {"$ref": "#/components/schemas/Coord"},
+          "weather": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "additionalProperties": false,
+              "properties": {
+                "id": {"type": "integer"},
+                "main": {"type": "string"},
+                "description": {"type": "string"},
+                "icon": {"type": "string"}
+              }
+            }
+          },
+          "base": {"type": "string"},
+          "main": {
+            "type": "object",
+            "additionalProperties": false,
+            "properties": {
+              "temp": {"type": "number"},
+              "pressure": {"type": "integer"},
+              "humidity": {"type": "integer"},
+              "temp_min": {"type": "number"},
+              "temp_max": {"type": "number"}
+            }
+          },
+          "visibility": {"type": "integer"},
+          "wind": {
+            "type": "object",
+            "additionalProperties": false,
+            "properties": {
+              "speed": {"type": "number"},
+              "deg": {"type": "integer"}
+            }
+          },
+          "clouds": {
+            "type": "object",
+            "additionalProperties": false,
+            "properties": {
+              "all": {"type": "integer"}
+            }
+          },
+          "dt": {"type": "integer", "description": "Time of observation, unix timestamp"},
+          "sys": {
+            "type": "object",
+            "additionalProperties": false,
+            "properties": {
+              "type": {"type": "integer"},
+              "id": {"type": "integer"},
+              "message": {"type": "number"},
+              "country": {"type": "string"},
+              "sunrise": {"type": "integer"},
+              "sunset": {"type": "integer"}
+            }
+          },
+          "id": {"type": "integer"},
+          "name": {"type": "string"},
+          "cod": {"type": "integer"}
+        }
+      },
+      "Coord": {
+        "type": "object",
+        "required": ["lat", "lon"],
+        "additionalProperties": false,
+        "properties": {
+          "lat": {"type": "number", "minimum": -90, "maximum": 90},
+          "lon": {"type": "number", "minimum": -180, "maximum": 180}
+        }
+      },
+      "History": {
+        "type": "object",
+        "required": ["city", "forecasts"],
+        "additionalProperties": false,
+        "properties": {
+          "city": {"type": "string"},
+          "forecasts": {"type": "array", "items": {"$ref": "#/components/schemas/Forecast"}}
+        }
+      },
+      "BatchRequest": {
+        "type": "object",
+        "required": ["locations"],
+        "additionalProperties": false,
+        "properties": {
+          "locations": {"type": "array", "minItems": 1, "items": {"$ref": "#/components/schemas/LocationQuery"}}
+        }
+      },
+      "LocationQuery": {
+        "type": "object",
+        "description": "Exactly one of name, id or coord is required",
+        "additionalProperties": false,
+        "properties": {
+          "name": {"type": "string", "maxLength": 100},
+          "id": {"type": "integer", "minimum": 1},
+          "coord": {"$ref": "#/components/schemas/Coord"},
+          "units": {"type": "string", "enum": ["standard", "metric", "imperial"]},
+          "lang": {"type": "string"}
+        }
+      },
+      "Problem": {
+        "type": "object",
+        "required": ["type", "status", "title", "code"],
+        "additionalProperties": false,
+        "properties": {
+          "type": {"type": "string"},
+          "status": {"type": "integer"},
+          "title": {"type": "string"},
+          "code": {
+            "type": "string",
+            "enum": [
+              "CITY_NOT_FOUND",
+              "INVALID_INPUT",
+              "NOT_ACCEPTABLE",
+              "UNAUTHORIZED",
+              "UPSTREAM_RATE_LIMITED",
+              "UPSTREAM_AUTH",
+              "UPSTREAM_UNAVAILABLE",
+              "UPSTREAM_TIMEOUT",
+              "INTERNAL"
+            ]
+          },
+          "detail": {"type": "string"},
+          "invalid_params": {
+            "type": "array",
+            "items": {
+              "type": "object",
+              "required": ["name", "reason"],
+              "additionalProperties": false,
+              "properties": {
+                "name": {"type": "string"},
+                "reason": {"type": "string"}
+              }
+            }
+          }
+        }
+      },
+      "CacheEntries": {
+        "type": "object",
+        "required": ["entries"],
+        "additionalProperties": false,
+        "properties": {
+          "entries": {"type": "array", "items": {"$ref": "#/components/schemas/CacheEntry"}}
+        }
+      },
+      "CacheEntry": {
+        "type": "object",
+        "required": ["city", "source", "stored_at", "age_seconds"],
+        "additionalProperties": false,
+        "properties": {
+          "city": {"type": "string"},
+          "source": {"type": "string"},
+          "stored_at": {"type": "string", "format": "date-time"},
+          "age_seconds": {"type": "integer"},
+          "ttl_remaining_seconds": {"type": "integer", "description": "Missing if entry never expires"}
+        }
+      }
+    }
+  }
+}
+`
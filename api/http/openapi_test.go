@@ -0,0 +1,208 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather/history/memory"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/cache"
+	"github.com/papisz/weather/weathersrc/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenAPI_ResponsesMatchSpec(t *testing.T) {
+	var spec map[string]interface{}
+	if err := json.Unmarshal([]byte(openAPISpec), &spec); err != nil {
+		t.Fatalf("spec is not valid JSON: %v", err)
+	}
+
+	storage := cache.NewWeatherSrc(cache.WithTTL(time.Hour))
+	historyStore := memory.NewStore()
+	a := NewApi(
+		WithHistoryStore(historyStore),
+		WithAdmin("secret", storage),
+		WithForecastManager(weathersrc.NewForecastManager(
+			weathersrc.WithExternalProvider(
+				file.NewWeatherSrc(
+					file.WithDirPath("../../testdata/source"),
+				),
+			),
+			weathersrc.WithStorageProvider(storage),
+			weathersrc.WithHistoryStore(historyStore),
+		)),
+	)
+	server := httptest.NewServer(a.Router())
+	defer server.Close()
+
+	tests := []struct {
+		method string
+		path   string
+		route  string
+		body   string
+		token  string
+		status int
+	}{
+		{method: "GET", path: "/forecast?city=london&city=warsaw", route: "/forecast", status: 200},
+		{method: "GET", path: "/forecast?city=szczebrzeszyn", route: "/forecast", status: 404},
+		{method: "GET", path: "/forecast?city=", route: "/forecast", status: 400},
+		{method: "GET", path: "/forecast?city=london&format=yaml", route: "/forecast", status: 406},
+		{method: "GET", path: "/forecast?city=london&max_age=60", route: "/forecast", status: 200},
+		{method: "GET", path: "/forecast?city=london&max_age=soon", route: "/forecast", status: 400},
+		{method: "GET", path: "/forecast?city=london&refresh=true", route: "/forecast", token: "secret", status: 200},
+		{method: "GET", path: "/forecast?city=london&refresh=true", route: "/forecast", status: 401},
+		{method: "POST", path: "/forecast", route: "/forecast", body: `{"locations": [{"name": "london"}]}`, status: 200},
+		{method: "POST", path: "/forecast", route: "/forecast", body: `{"locations": [{"id": -1}]}`, status: 400},
+		{method: "GET", path: "/history?city=london", route: "/history", status: 200},
+		{method: "GET", path: "/admin/cache", route: "/admin/cache", token: "secret", status: 200},
+		{method: "GET", path: "/admin/cache", route: "/admin/cache", status: 401},
+		{method: "DELETE", path: "/admin/cache/nowhere", route: "/admin/cache/{city}", token: "secret", status: 404},
+		{method: "GET", path: "/openapi.json", route: "/openapi.json", status: 200},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s %d", tt.method, tt.path, tt.status), func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, server.URL+tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			assert.Equal(t, tt.status, resp.StatusCode)
+
+			response, ok := lookup(spec, spec, "paths", tt.route, strings.ToLower(tt.method), "responses", strconv.Itoa(resp.StatusCode)).(map[string]interface{})
+			if !ok {
+				t.Fatalf("response %d of %s %s is not documented", resp.StatusCode, tt.method, tt.route)
+			}
+
+			mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+			content, ok := lookup(spec, response, "content", mediaType).(map[string]interface{})
+			if !ok {
+				t.Fatalf("content type %s is not documented", mediaType)
+			}
+			schema, ok := content["schema"]
+			if !ok {
+				return
+			}
+
+			var body interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("unable to decode body: %v", err)
+			}
+			for _, violation := range validate(spec, schema, body, "body") {
+				t.Error(violation)
+			}
+		})
+	}
+}
+
+// lookup walks spec following $ref, returning nil if path doesn't exist
+func lookup(spec map[string]interface{}, node interface{}, path ...string) interface{} {
+	for _, key := range path {
+		node = resolve(spec, node)
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		node = m[key]
+	}
+	return resolve(spec, node)
+}
+
+func resolve(spec map[string]interface{}, node interface{}) interface{} {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return node
+	}
+	ref, ok := m["$ref"].(string)
+	if !ok {
+		return node
+	}
+	return lookup(spec, spec, strings.Split(strings.TrimPrefix(ref, "#/"), "/")...)
+}
+
+// validate checks value against subset of JSON schema used in the spec
+func validate(spec map[string]interface{}, schemaNode interface{}, value interface{}, at string) []string {
+	schema, _ := resolve(spec, schemaNode).(map[string]interface{})
+	var violations []string
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			found = found || e == value
+		}
+		if !found {
+			violations = append(violations, fmt.Sprintf("%s: %v is not one of %v", at, value, enum))
+		}
+	}
+
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return append(violations, fmt.Sprintf("%s: expected object, got %T", at, value))
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, r := range required {
+			if _, ok := obj[r.(string)]; !ok {
+				violations = append(violations, fmt.Sprintf("%s: missing required %s", at, r))
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if propSchema, ok := properties[k]; ok {
+				violations = append(violations, validate(spec, propSchema, obj[k], at+"."+k)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					violations = append(violations, fmt.Sprintf("%s: unexpected property %s", at, k))
+				}
+			case map[string]interface{}:
+				violations = append(violations, validate(spec, additional, obj[k], at+"."+k)...)
+			}
+		}
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return append(violations, fmt.Sprintf("%s: expected array, got %T", at, value))
+		}
+		for i, item := range arr {
+			violations = append(violations, validate(spec, schema["items"], item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			violations = append(violations, fmt.Sprintf("%s: expected string, got %T", at, value))
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != float64(int64(n)) {
+			violations = append(violations, fmt.Sprintf("%s: expected integer, got %v", at, value))
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			violations = append(violations, fmt.Sprintf("%s: expected number, got %T", at, value))
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			violations = append(violations, fmt.Sprintf("%s: expected boolean, got %T", at, value))
+		}
+	}
+	return violations
+}
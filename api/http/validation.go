@@ -0,0 +1,56 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxCityLength = 100
+
+// validateCity returns normalized city name, or reason why it's invalid
+func validateCity(city string) (string, string) {
+	if !utf8.ValidString(city) {
+		return "", "must be valid UTF-8"
+	}
+	for _, c := range city {
+		if unicode.IsControl(c) {
+			return "", "must not contain control characters"
+		}
+	}
+
+	city = strings.Join(strings.Fields(city), " ")
+	switch {
+	case city == "":
+		return "", "must not be empty"
+	case utf8.RuneCountInString(city) > maxCityLength:
+		return "", fmt.Sprintf("must be at most %d characters long", maxCityLength)
+	}
+	return city, ""
+}
+
+// validateCities normalizes cities and removes duplicates, keeping the first
+// spelling. It lists every invalid input.
+func (a *HTTPApi) validateCities(cities []string) ([]string, []InvalidParam) {
+	if a.MaxBatchSize > 0 && len(cities) > a.MaxBatchSize {
+		return nil, []InvalidParam{{Name: "city", Reason: fmt.Sprintf("at most %d cities are allowed", a.MaxBatchSize)}}
+	}
+
+	var invalid []InvalidParam
+	valid := make([]string, 0, len(cities))
+	seen := map[string]bool{}
+	for i, city := range cities {
+		city, reason := validateCity(city)
+		if reason != "" {
+			invalid = append(invalid, InvalidParam{Name: fmt.Sprintf("city[%d]", i), Reason: reason})
+			continue
+		}
+
+		if key := strings.ToLower(city); !seen[key] {
+			seen[key] = true
+			valid = append(valid, city)
+		}
+	}
+	return valid, invalid
+}
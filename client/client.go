@@ -0,0 +1,256 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/papisz/weather"
+)
+
+// ErrInvalidInput means that the service rejected request parameters
+var ErrInvalidInput = errors.New("invalid input")
+
+// Client calls weather HTTP API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+	retries    int
+	retryWait  time.Duration
+	maxWait    time.Duration
+}
+
+// Error is returned for unsuccessful responses. It unwraps to matching error
+// from weather package, so it can be checked with errors.Is.
+type Error struct {
+	StatusCode    int
+	Code          string
+	Title         string
+	Detail        string
+	InvalidParams []InvalidParam
+	RetryAfter    time.Duration
+}
+
+// InvalidParam describes why a single input was rejected
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("weather api returned %d: %s: %s", e.StatusCode, e.Title, e.Detail)
+	}
+	return fmt.Sprintf("weather api returned %d: %s", e.StatusCode, e.Title)
+}
+
+func (e *Error) Unwrap() error {
+	switch e.Code {
+	case "CITY_NOT_FOUND":
+		return weather.ErrForecastNotFound
+	case "UPSTREAM_RATE_LIMITED":
+		return weather.ErrTooManyRequests
+	case "UPSTREAM_AUTH":
+		return weather.ErrMisconfigured
+	case "UPSTREAM_UNAVAILABLE":
+		return weather.ErrUnavailable
+	case "UPSTREAM_TIMEOUT":
+		return weather.ErrTimeout
+	case "INVALID_INPUT":
+		return ErrInvalidInput
+	}
+	return weather.ErrInternal
+}
+
+// temporary tells if request may succeed when retried
+func (e *Error) temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+type Option func(c *Client)
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		retries:    2,
+		retryWait:  500 * time.Millisecond,
+		maxWait:    30 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	// timeout is set on a copy, so HTTP client given with WithHTTPClient
+	// isn't changed for its other users
+	if c.timeout > 0 {
+		httpClient := *c.httpClient
+		httpClient.Timeout = c.timeout
+		c.httpClient = &httpClient
+	}
+	return c
+}
+
+// WithURL sets base URL of the service, e.g. http://localhost:5555
+func WithURL(baseURL string) Option {
+	return func(c *Client) {
+		c.baseURL = baseURL
+	}
+}
+
+// WithHTTPClient replaces default HTTP client
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTimeout limits time of a single attempt, whether it's given before or
+// after WithHTTPClient. Timeout of the HTTP client is used if it's not positive.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetries sets how many times failed request is retried. Only network
+// errors, rate limits and upstream failures are retried.
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// WithRetryWait sets initial wait between retries, doubled after every attempt.
+// Retry-After returned by the service takes precedence, up to maxWait.
+func WithRetryWait(wait, maxWait time.Duration) Option {
+	return func(c *Client) {
+		c.retryWait = wait
+		c.maxWait = maxWait
+	}
+}
+
+// GetForecasts returns forecasts for cities, keyed by city
+func (c *Client) GetForecasts(ctx context.Context, cities ...string) (*weather.Forecasts, error) {
+	v := url.Values{}
+	for _, city := range cities {
+		v.Add("city", city)
+	}
+
+	forecasts := weather.NewForecasts()
+	if err := c.get(ctx, "/forecast?"+v.Encode(), forecasts); err != nil {
+		return nil, err
+	}
+	return forecasts, nil
+}
+
+// GetHistory returns archived forecasts for city in given time range
+func (c *Client) GetHistory(ctx context.Context, city string, from, to time.Time) (*weather.History, error) {
+	v := url.Values{}
+	v.Set("city", city)
+	v.Set("from", strconv.FormatInt(from.Unix(), 10))
+	v.Set("to", strconv.FormatInt(to.Unix(), 10))
+
+	history := &weather.History{}
+	if err := c.get(ctx, "/history?"+v.Encode(), history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	wait := c.retryWait
+
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, path, v)
+		if err == nil || attempt >= c.retries || !retryable(err) {
+			return err
+		}
+
+		sleep := wait
+		var apiErr *Error
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			sleep = apiErr.RetryAfter
+		}
+		if sleep > c.maxWait {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(sleep):
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) do(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return decodeError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("unable to decode response: %v", err)
+	}
+	return nil
+}
+
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{StatusCode: resp.StatusCode, Title: http.StatusText(resp.StatusCode)}
+
+	var problem struct {
+		Title         string         `json:"title"`
+		Code          string         `json:"code"`
+		Detail        string         `json:"detail"`
+		InvalidParams []InvalidParam `json:"invalid_params"`
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err := json.Unmarshal(body, &problem); err == nil && problem.Title != "" {
+		apiErr.Title = problem.Title
+		apiErr.Code = problem.Code
+		apiErr.Detail = problem.Detail
+		apiErr.InvalidParams = problem.InvalidParams
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return apiErr
+}
+
+// retryable tells if error is temporary: a network error or a temporary API error
+func retryable(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.temporary()
+	}
+
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	api "github.com/papisz/weather/api/http"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/cache"
+	"github.com/papisz/weather/weathersrc/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClient_GetForecasts(t *testing.T) {
+	server := httptest.NewServer(api.NewApi(
+		api.WithForecastManager(weathersrc.NewForecastManager(
+			weathersrc.WithExternalProvider(
+				file.NewWeatherSrc(
+					file.WithDirPath("../testdata/source"),
+				),
+			),
+			weathersrc.WithStorageProvider(
+				cache.NewWeatherSrc(cache.WithTTL(5*time.Second)),
+			),
+		)),
+	).Router())
+	defer server.Close()
+
+	c := NewClient(WithURL(server.URL))
+	source := file.NewWeatherSrc(file.WithDirPath("../testdata/source"))
+	london, _ := source.GetForecast("london")
+	warsaw, _ := source.GetForecast("warsaw")
+
+	forecasts, err := c.GetForecasts(context.Background(), "london", "warsaw")
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecasts.Cities["london"])
+	assert.Equal(t, warsaw, forecasts.Cities["warsaw"])
+
+	_, err = c.GetForecasts(context.Background(), "london", "szczebrzeszyn")
+	assert.True(t, errors.Is(err, weather.ErrForecastNotFound))
+
+	_, err = c.GetForecasts(context.Background(), "")
+	assert.True(t, errors.Is(err, ErrInvalidInput))
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, []InvalidParam{{Name: "city[0]", Reason: "must not be empty"}}, apiErr.InvalidParams)
+}
+
+func TestClient_Retries(t *testing.T) {
+	tests := []struct {
+		name          string
+		errs          []error
+		retries       int
+		expectedErr   error
+		expectedCalls int
+	}{
+		{
+			name:          "Succeeds after temporary failures",
+			errs:          []error{weather.ErrUnavailable, weather.ErrTimeout},
+			retries:       2,
+			expectedCalls: 3,
+		},
+		{
+			name:          "Gives up after retries",
+			errs:          []error{weather.ErrUnavailable, weather.ErrUnavailable, weather.ErrUnavailable},
+			retries:       2,
+			expectedErr:   weather.ErrUnavailable,
+			expectedCalls: 3,
+		},
+		{
+			name:          "Honors Retry-After",
+			errs:          []error{&weather.RetryAfterError{Err: weather.ErrTooManyRequests, RetryAfter: time.Millisecond}},
+			retries:       1,
+			expectedCalls: 2,
+		},
+		{
+			name:          "Doesn't wait longer than max wait",
+			errs:          []error{&weather.RetryAfterError{Err: weather.ErrTooManyRequests, RetryAfter: time.Hour}},
+			retries:       1,
+			expectedErr:   weather.ErrTooManyRequests,
+			expectedCalls: 1,
+		},
+		{
+			name:          "Permanent errors aren't retried",
+			errs:          []error{weather.ErrForecastNotFound},
+			retries:       2,
+			expectedErr:   weather.ErrForecastNotFound,
+			expectedCalls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &flakyManager{errs: tt.errs}
+			server := httptest.NewServer(api.NewApi(api.WithForecastManager(m)).Router())
+			defer server.Close()
+
+			c := NewClient(
+				WithURL(server.URL),
+				WithRetries(tt.retries),
+				WithRetryWait(time.Millisecond, time.Minute),
+			)
+
+			_, err := c.GetForecasts(context.Background(), "london")
+			if tt.expectedErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.expectedErr), "unexpected error %v", err)
+			}
+			assert.Equal(t, tt.expectedCalls, m.calls)
+		})
+	}
+}
+
+func TestClient_ContextCanceled(t *testing.T) {
+	m := &flakyManager{errs: []error{weather.ErrUnavailable, weather.ErrUnavailable}}
+	server := httptest.NewServer(api.NewApi(api.WithForecastManager(m)).Router())
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c := NewClient(WithURL(server.URL), WithRetryWait(time.Second, time.Minute))
+
+	_, err := c.GetForecasts(ctx, "london")
+	assert.True(t, errors.Is(err, weather.ErrUnavailable))
+	assert.Equal(t, 1, m.calls)
+}
+
+// flakyManager fails with errs one by one, then returns empty forecasts
+type flakyManager struct {
+	errs  []error
+	calls int
+}
+
+func (m *flakyManager) GetForecasts(cities ...string) (*weather.Forecasts, error) {
+	return m.QueryForecasts()
+}
+
+func (m *flakyManager) QueryForecasts(queries ...weather.Query) (*weather.Forecasts, error) {
+	m.calls++
+	if len(m.errs) > 0 {
+		err := m.errs[0]
+		m.errs = m.errs[1:]
+		return nil, err
+	}
+	return weather.NewForecasts(), nil
+}
+
+func TestClient_WithTimeout(t *testing.T) {
+	shared := &http.Client{Timeout: time.Minute}
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{
+			name: "Timeout after shared client",
+			opts: []Option{WithHTTPClient(shared), WithTimeout(time.Second)},
+		},
+		{
+			name: "Timeout on default client",
+			opts: []Option{WithHTTPClient(http.DefaultClient), WithTimeout(time.Second)},
+		},
+		{
+			name: "Timeout before shared client",
+			opts: []Option{WithTimeout(time.Second), WithHTTPClient(shared)},
+		},
+		{
+			name: "Last timeout wins",
+			opts: []Option{WithTimeout(time.Hour), WithHTTPClient(shared), WithTimeout(time.Second)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClient(tt.opts...)
+
+			assert.Equal(t, time.Second, c.httpClient.Timeout)
+			assert.Equal(t, time.Minute, shared.Timeout)
+			assert.Equal(t, time.Duration(0), http.DefaultClient.Timeout)
+		})
+	}
+
+	// without a timeout the given client is used as it is
+	c := NewClient(WithHTTPClient(shared))
+	assert.Same(t, shared, c.httpClient)
+}
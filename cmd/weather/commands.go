@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/api/http"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/cache"
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, `Usage: weather [-help] [-print-config] [-config file] [flags] [command]
+
+Commands:
+  serve                                  start HTTP server (default)
+  get [-units u] [-lang l] [-format f] [-refresh] city...
+                                         print forecasts, format is table or json
+  cache warm city...                     fetch forecasts into storage
+  cache dump [-format f]                 list stored forecasts, format is table or json
+  cache purge [city...]                  remove given or all stored forecasts
+
+Cache commands are useful with persistent storage (WEATHER_STORAGEDIR or
+WEATHER_STORAGE), in-memory cache lives only as long as the process.
+
+Run offline with -offline -file-dir testdata/source, record fixtures for it
+with -record-dir dir.
+
+Config is read from defaults, YAML file given by -config (or WEATHER_CONFIG),
+env vars and flags, each one overriding the previous. serve reloads it on
+SIGHUP or when the file changes, applying API keys, TTLs and cache limits.
+Secrets can be read from files given by WEATHER_WEATHERSRCAPIKEY_FILE,
+WEATHER_WEATHERSRCAPIKEYS_FILE and WEATHER_ADMINTOKEN_FILE.
+
+Flags:
+`)
+	flag.PrintDefaults()
+	fmt.Fprintln(out)
+	envconfig.Usage(envPrefix, &Config{})
+}
+
+func serve(config *Config) error {
+	log.Printf("starting with config:")
+	if err := config.Print(log.Writer()); err != nil {
+		return err
+	}
+
+	historyStore, err := newHistoryStore(config)
+	if err != nil {
+		return err
+	}
+	p, err := newProviders(config)
+	if err != nil {
+		return err
+	}
+
+	go watchConfig(flag.CommandLine, &reloader{config: config, providers: p}, configPollInterval, nil)
+
+	return http.NewApi(
+		http.WithListenAddress(config.Listen),
+		http.WithHistoryStore(historyStore),
+		http.WithAdmin(config.AdminToken.Value(), p.storage),
+		http.WithMaxBatchSize(config.MaxBatchSize),
+		http.WithForecastManager(newManager(p.external, p.storage, historyStore)),
+	).Serve()
+}
+
+func get(w io.Writer, config *Config, args []string) error {
+	fs := flag.NewFlagSet("get", flag.ExitOnError)
+	units := fs.String("units", "", "standard, metric or imperial")
+	lang := fs.String("lang", "", "language of descriptions, like pl")
+	format := fs.String("format", "table", "table or json")
+	refresh := fs.Bool("refresh", false, "fetch from external provider even if forecast is stored")
+	cities := parseInterspersed(fs, args)
+	if len(cities) == 0 {
+		return errors.New("at least one city is required")
+	}
+
+	queries := make([]weather.Query, 0, len(cities))
+	for _, city := range cities {
+		queries = append(queries, weather.Query{City: city, Units: *units, Lang: *lang, Refresh: *refresh})
+	}
+
+	p, err := newProviders(config)
+	if err != nil {
+		return err
+	}
+	forecasts, err := newManager(p.external, p.storage, nil).QueryForecasts(queries...)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "json":
+		return printJSON(w, forecasts)
+	case "table":
+		return printForecasts(w, forecasts, *units)
+	}
+	return fmt.Errorf("unknown format %q", *format)
+}
+
+func cacheCommand(w io.Writer, config *Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("subcommand is required: warm, dump or purge")
+	}
+
+	tiers := config.StorageTiers()
+	if len(tiers) == 1 && tiers[0] == cache.Source {
+		fmt.Fprintln(os.Stderr, "warning: only in-memory storage is configured, cache is not persisted")
+	}
+	p, err := newProviders(config)
+	if err != nil {
+		return err
+	}
+	storage := p.storage
+
+	switch args[0] {
+	case "warm":
+		cities := args[1:]
+		if len(cities) == 0 {
+			return errors.New("at least one city is required")
+		}
+		_, err := newManager(p.external, storage, nil).GetForecasts(cities...)
+		return err
+	case "dump":
+		fs := flag.NewFlagSet("cache dump", flag.ExitOnError)
+		format := fs.String("format", "table", "table or json")
+		fs.Parse(args[1:])
+
+		entries, err := storage.ListForecasts()
+		if err != nil {
+			return err
+		}
+		switch *format {
+		case "json":
+			return printJSON(w, entries)
+		case "table":
+			return printEntries(w, entries)
+		}
+		return fmt.Errorf("unknown format %q", *format)
+	case "purge":
+		cities := args[1:]
+		if len(cities) == 0 {
+			return storage.Flush()
+		}
+		for _, city := range cities {
+			if err := weathersrc.DeleteCity(storage, city); err != nil && !errors.Is(err, weather.ErrForecastNotFound) {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown subcommand %q", args[0])
+}
+
+// parseInterspersed parses flags given before, between or after positional
+// arguments and returns the positional ones
+func parseInterspersed(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func printJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+var temperatureUnits = map[string]string{
+	"":         "K",
+	"standard": "K",
+	"metric":   "°C",
+	"imperial": "°F",
+}
+
+func printForecasts(w io.Writer, forecasts *weather.Forecasts, units string) error {
+	cities := make([]string, 0, len(forecasts.Cities))
+	for city := range forecasts.Cities {
+		cities = append(cities, city)
+	}
+	sort.Strings(cities)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CITY\tNAME\tCOUNTRY\tTEMP\tHUMIDITY\tWIND\tDESCRIPTION\tOBSERVED\tSOURCE")
+	for _, city := range cities {
+		f := forecasts.Cities[city]
+		var descriptions []string
+		for _, w := range f.Weather {
+			descriptions = append(descriptions, w.Description)
+		}
+		source := ""
+		if meta := forecasts.Meta[city]; meta != nil {
+			source = meta.Source
+			if meta.Stale {
+				source += " (stale)"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f%s\t%d%%\t%.1f\t%s\t%s\t%s\n",
+			city, f.Name, f.Sys.Country,
+			f.Main.Temp, temperatureUnits[units],
+			f.Main.Humidity, f.Wind.Speed,
+			strings.Join(descriptions, ", "),
+			time.Unix(int64(f.Dt), 0).Format(time.RFC3339),
+			source,
+		)
+	}
+	return tw.Flush()
+}
+
+func printEntries(w io.Writer, entries []weathersrc.Entry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CITY\tSOURCE\tSTORED\tEXPIRES")
+	for _, e := range entries {
+		expires := "never"
+		if !e.ExpiresAt.IsZero() {
+			expires = e.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.City, e.Source, e.StoredAt.Format(time.RFC3339), expires)
+	}
+	return tw.Flush()
+}
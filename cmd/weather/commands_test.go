@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/stretchr/testify/assert"
+)
+
+// offlineConfig serves forecasts from testdata files and stores them in dir
+func offlineConfig(t *testing.T, dir string) *Config {
+	config := defaultConfig(t)
+	config.Offline = true
+	config.FileDir = "../../testdata/source"
+	config.StorageDir = dir
+	return config
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "weather")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// inUTC makes tables print times in UTC, they're printed in local time zone
+func inUTC(t *testing.T) {
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+}
+
+func TestParseInterspersed(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		want      []string
+		wantUnits string
+		wantJSON  bool
+	}{
+		{
+			name: "No flags",
+			args: []string{"London", "Warsaw"},
+			want: []string{"London", "Warsaw"},
+		},
+		{
+			name:      "Flags before, between and after",
+			args:      []string{"-units", "metric", "London", "-json", "Warsaw", "-units=imperial"},
+			want:      []string{"London", "Warsaw"},
+			wantUnits: "imperial",
+			wantJSON:  true,
+		},
+		{
+			name:      "Only flags",
+			args:      []string{"-units", "metric"},
+			wantUnits: "metric",
+		},
+		{
+			name: "Arguments after terminator",
+			args: []string{"London", "--", "-json"},
+			want: []string{"London", "-json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			units := fs.String("units", "", "")
+			asJSON := fs.Bool("json", false, "")
+
+			assert.Equal(t, tt.want, parseInterspersed(fs, tt.args))
+			assert.Equal(t, tt.wantUnits, *units)
+			assert.Equal(t, tt.wantJSON, *asJSON)
+		})
+	}
+}
+
+func TestPrintForecasts(t *testing.T) {
+	forecasts := weather.NewForecasts()
+	london := &weather.Forecast{Name: "London", Dt: 1588016537}
+	london.Sys.Country = "GB"
+	london.Main.Temp = 14.56
+	london.Main.Humidity = 62
+	london.Wind.Speed = 6.2
+	london.Weather = make([]struct {
+		ID          int    `json:"id" xml:"id"`
+		Main        string `json:"main" xml:"main"`
+		Description string `json:"description" xml:"description"`
+		Icon        string `json:"icon" xml:"icon"`
+	}, 2)
+	london.Weather[0].Description = "broken clouds"
+	london.Weather[1].Description = "mist"
+	forecasts.Cities["london"] = london
+	forecasts.Meta["london"] = &weather.Freshness{Source: "file", Stale: true}
+	forecasts.Cities["berlin"] = &weather.Forecast{Name: "Berlin", Dt: 1588016806}
+
+	inUTC(t)
+
+	var out bytes.Buffer
+	assert.Nil(t, printForecasts(&out, forecasts, "metric"))
+
+	assert.Equal(t, ""+
+		"CITY    NAME    COUNTRY  TEMP    HUMIDITY  WIND  DESCRIPTION          OBSERVED              SOURCE\n"+
+		"berlin  Berlin           0.0°C   0%        0.0                        2020-04-27T19:46:46Z  \n"+
+		"london  London  GB       14.6°C  62%       6.2   broken clouds, mist  2020-04-27T19:42:17Z  file (stale)\n",
+		out.String())
+}
+
+func TestGet(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "Table",
+			args: []string{"Warsaw", "London"},
+			want: "" +
+				"CITY    NAME    COUNTRY  TEMP    HUMIDITY  WIND  DESCRIPTION    OBSERVED              SOURCE\n" +
+				"London  London  GB       287.7K  62%       6.2   broken clouds  2020-04-27T19:42:17Z  file\n" +
+				"Warsaw  Warsaw  PL       286.7K  35%       2.1   clear sky      2020-04-27T19:46:46Z  file\n",
+		},
+		{
+			name:    "Units after city",
+			args:    []string{"London", "-units", "imperial"},
+			wantErr: "error fetching forecast from external provider for London: query not supported by file provider: units",
+		},
+		{
+			name:    "No cities",
+			args:    []string{"-units", "metric"},
+			wantErr: "at least one city is required",
+		},
+		{
+			name:    "Unknown format",
+			args:    []string{"-format", "xml", "London"},
+			wantErr: `unknown format "xml"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inUTC(t)
+
+			var out bytes.Buffer
+			err := get(&out, offlineConfig(t, tempDir(t)), tt.args)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
+
+func TestGet_JSON(t *testing.T) {
+	var out bytes.Buffer
+	assert.Nil(t, get(&out, offlineConfig(t, tempDir(t)), []string{"-format", "json", "London"}))
+
+	var forecasts weather.Forecasts
+	assert.Nil(t, json.Unmarshal(out.Bytes(), &forecasts))
+	assert.Equal(t, "London", forecasts.Cities["London"].Name)
+	assert.Equal(t, "file", forecasts.Meta["London"].Source)
+	assert.False(t, forecasts.Meta["London"].CacheHit)
+}
+
+func TestCacheCommand(t *testing.T) {
+	tests := []struct {
+		name       string
+		stored     []string
+		args       []string
+		wantCities []string
+		wantErr    string
+	}{
+		{
+			name:       "Warm",
+			args:       []string{"warm", "London", "Warsaw"},
+			wantCities: []string{"London", "Warsaw"},
+		},
+		{
+			name:       "Warm unknown city",
+			args:       []string{"warm", "Paris"},
+			wantErr:    "error fetching forecast from external provider for Paris: forecast not found",
+			wantCities: []string{},
+		},
+		{
+			name:       "Purge city",
+			stored:     []string{"London", "Warsaw"},
+			args:       []string{"purge", "london", "Paris"},
+			wantCities: []string{"Warsaw"},
+		},
+		{
+			name:       "Purge all",
+			stored:     []string{"London", "Warsaw"},
+			args:       []string{"purge"},
+			wantCities: []string{},
+		},
+		{
+			name:       "Dump",
+			stored:     []string{"London"},
+			args:       []string{"dump", "-format", "json"},
+			wantCities: []string{"London"},
+		},
+		{
+			name:       "Unknown dump format",
+			stored:     []string{"London"},
+			args:       []string{"dump", "-format", "xml"},
+			wantErr:    `unknown format "xml"`,
+			wantCities: []string{"London"},
+		},
+		{
+			name:       "Unknown subcommand",
+			args:       []string{"fill"},
+			wantErr:    `unknown subcommand "fill"`,
+			wantCities: []string{},
+		},
+		{
+			name:       "No subcommand",
+			wantErr:    "subcommand is required: warm, dump or purge",
+			wantCities: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := offlineConfig(t, tempDir(t))
+			if len(tt.stored) > 0 {
+				assert.Nil(t, cacheCommand(ioutil.Discard, config, append([]string{"warm"}, tt.stored...)))
+			}
+
+			err := cacheCommand(ioutil.Discard, config, tt.args)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.ElementsMatch(t, tt.wantCities, storedCities(t, config))
+		})
+	}
+}
+
+func TestCacheCommand_DumpTable(t *testing.T) {
+	config := offlineConfig(t, tempDir(t))
+	assert.Nil(t, cacheCommand(ioutil.Discard, config, []string{"warm", "London"}))
+
+	var out bytes.Buffer
+	assert.Nil(t, cacheCommand(&out, config, []string{"dump"}))
+
+	assert.Regexp(t, `^CITY\s+SOURCE\s+STORED\s+EXPIRES\n`+
+		`London\s+disk\s+\d{4}-\d\d-\d\dT\S+\s+\d{4}-\d\d-\d\dT\S+\n$`, out.String())
+}
+
+// storedCities lists cities in storage, as printed by cache dump
+func storedCities(t *testing.T, config *Config) []string {
+	var out bytes.Buffer
+	if err := cacheCommand(&out, config, []string{"dump", "-format", "json"}); err != nil {
+		t.Fatal(err)
+	}
+	var entries []weathersrc.Entry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	cities := []string{}
+	for _, e := range entries {
+		cities = append(cities, e.City)
+	}
+	return cities
+}
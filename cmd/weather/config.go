@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/cache"
+	"github.com/papisz/weather/weathersrc/disk"
+	"github.com/papisz/weather/weathersrc/file"
+	"github.com/papisz/weather/weathersrc/openweather"
+	"gopkg.in/yaml.v2"
+)
+
+const envPrefix = "weather"
+
+// Config is read from defaults, YAML file, WEATHER_* env vars and flags,
+// each one overriding the previous. Keys in the file and flag names are
+// derived from yaml tags. Secrets can be also read from files given by
+// env vars with _FILE suffix, like WEATHER_WEATHERSRCAPIKEY_FILE.
+type Config struct {
+	Listen                    string           `yaml:"listen" default:"localhost:5555"`
+	WeatherSrcAPIKey          weather.Secret   `yaml:"weather_src_api_key"`
+	WeatherSrcAPIKeys         []weather.Secret `yaml:"weather_src_api_keys" desc:"comma-separated list of API keys used in rotation"`
+	WeatherSrcAPIURL          string           `yaml:"weather_src_api_url" default:"https://api.openweathermap.org/data/2.5/weather"`
+	CacheTTL                  time.Duration    `yaml:"cache_ttl" default:"5h"`
+	CacheMaxEntries           int              `yaml:"cache_max_entries" default:"10000" desc:"max number of cached cities, 0 means no limit"`
+	CacheMaxBytes             int64            `yaml:"cache_max_bytes" desc:"max approximate size of cached forecasts in bytes, 0 means no limit"`
+	StorageDir                string           `yaml:"storage_dir" desc:"directory for persistent forecast storage, disabled if empty"`
+	StorageTTL                time.Duration    `yaml:"storage_ttl" default:"5h"`
+	StorageCompactionInterval time.Duration    `yaml:"storage_compaction_interval" desc:"how often expired forecasts are removed from storage_dir, storage_ttl if 0"`
+	HistoryMaxAge             time.Duration    `yaml:"history_max_age" default:"168h"`
+	HistoryMaxEntries         int              `yaml:"history_max_entries" default:"1000" desc:"max number of archived forecasts per city"`
+	HistoryDB                 string           `yaml:"history_db" desc:"SQLite database file keeping forecast history, in memory if empty"`
+	AdminToken                weather.Secret   `yaml:"admin_token" desc:"bearer token for /admin endpoints, disabled if empty"`
+	External                  []string         `yaml:"external" default:"openweather" desc:"external providers asked in order, like openweather,file"`
+	Storage                   []string         `yaml:"storage" desc:"storage tiers from the fastest, memory and disk if storage_dir is set by default"`
+	FileDir                   string           `yaml:"file_dir" desc:"directory with <city>.json forecasts for file provider"`
+	SyntheticSeed             int64            `yaml:"synthetic_seed" desc:"seed of forecasts generated by synthetic provider"`
+	SyntheticPeriod           time.Duration    `yaml:"synthetic_period" desc:"how long synthetic forecast for a city stays the same, 10m if 0"`
+	SyntheticMinLatency       time.Duration    `yaml:"synthetic_min_latency" desc:"min random latency added by synthetic provider"`
+	SyntheticLatency          time.Duration    `yaml:"synthetic_latency" desc:"max random latency added by synthetic provider"`
+	SyntheticErrorRate        float64          `yaml:"synthetic_error_rate" desc:"share of synthetic provider calls failing, from 0 to 1"`
+	ChaosErrorRate            float64          `yaml:"chaos_error_rate" desc:"share of external calls failing with rate limit, timeout or auth error, for staging"`
+	ChaosLatency              time.Duration    `yaml:"chaos_latency" desc:"latency spike added to chaos_latency_rate share of external calls"`
+	ChaosLatencyRate          float64          `yaml:"chaos_latency_rate" desc:"share of external calls delayed by chaos_latency"`
+	ChaosCorruptRate          float64          `yaml:"chaos_corrupt_rate" desc:"share of external calls failing to decode a corrupted response"`
+	Offline                   bool             `yaml:"offline" desc:"serve forecasts from file_dir only, external setting is ignored"`
+	RecordDir                 string           `yaml:"record_dir" desc:"directory where forecasts fetched from external APIs are recorded for offline replay"`
+	MaxBatchSize              int              `yaml:"max_batch_size" default:"100" desc:"max number of locations in a single /forecast request"`
+}
+
+// APIKeys returns all configured weather source API keys
+func (c *Config) APIKeys() []string {
+	var keys []string
+	if c.WeatherSrcAPIKey != "" {
+		keys = append(keys, c.WeatherSrcAPIKey.Value())
+	}
+	for _, k := range c.WeatherSrcAPIKeys {
+		if k != "" {
+			keys = append(keys, k.Value())
+		}
+	}
+	return keys
+}
+
+// ExternalChain returns configured external providers, only file provider in
+// offline mode
+func (c *Config) ExternalChain() []string {
+	if c.Offline {
+		return []string{file.Source}
+	}
+	return c.External
+}
+
+// StorageTiers returns configured storage stack, memory backed by disk if
+// storage dir is set when it's not given explicitly
+func (c *Config) StorageTiers() []string {
+	if len(c.Storage) > 0 {
+		return c.Storage
+	}
+	if c.StorageDir != "" {
+		return []string{cache.Source, disk.Source}
+	}
+	return []string{cache.Source}
+}
+
+// Validate checks config values, errors name the offending key
+func (c *Config) Validate() error {
+	var errs []string
+	fail := func(key, format string, args ...interface{}) {
+		errs = append(errs, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	if c.Listen == "" {
+		fail("listen", "must not be empty")
+	}
+	if len(c.External) == 0 {
+		fail("external", "at least one provider is required")
+	}
+	for _, name := range c.ExternalChain() {
+		if !contains(weathersrc.ExternalProviders(), name) {
+			fail("external", "unknown provider %q, available: %s", name, strings.Join(weathersrc.ExternalProviders(), ", "))
+		}
+	}
+	for _, name := range c.Storage {
+		if !contains(weathersrc.StorageProviders(), name) {
+			fail("storage", "unknown provider %q, available: %s", name, strings.Join(weathersrc.StorageProviders(), ", "))
+		}
+	}
+	if contains(c.ExternalChain(), openweather.Source) {
+		if len(c.APIKeys()) == 0 {
+			fail("weather_src_api_key", "either weather_src_api_key or weather_src_api_keys is required")
+		}
+		if u, err := url.Parse(c.WeatherSrcAPIURL); err != nil || u.Scheme == "" || u.Host == "" {
+			fail("weather_src_api_url", "%q is not an absolute URL", c.WeatherSrcAPIURL)
+		}
+	}
+	if contains(c.ExternalChain(), file.Source) && c.FileDir == "" {
+		fail("file_dir", "is required by file provider")
+	}
+	for key, rate := range map[string]float64{
+		"synthetic_error_rate": c.SyntheticErrorRate,
+		"chaos_error_rate":     c.ChaosErrorRate,
+		"chaos_latency_rate":   c.ChaosLatencyRate,
+		"chaos_corrupt_rate":   c.ChaosCorruptRate,
+	} {
+		if rate < 0 || rate > 1 {
+			fail(key, "must be between 0 and 1, got %v", rate)
+		}
+	}
+	if contains(c.StorageTiers(), disk.Source) && c.StorageDir == "" {
+		fail("storage_dir", "is required by disk storage")
+	}
+	for key, d := range map[string]time.Duration{
+		"cache_ttl":                   c.CacheTTL,
+		"storage_ttl":                 c.StorageTTL,
+		"storage_compaction_interval": c.StorageCompactionInterval,
+		"history_max_age":             c.HistoryMaxAge,
+		"synthetic_period":            c.SyntheticPeriod,
+		"synthetic_min_latency":       c.SyntheticMinLatency,
+		"synthetic_latency":           c.SyntheticLatency,
+		"chaos_latency":               c.ChaosLatency,
+	} {
+		if d < 0 {
+			fail(key, "must not be negative, got %s", d)
+		}
+	}
+	for key, n := range map[string]int64{
+		"cache_max_entries":   int64(c.CacheMaxEntries),
+		"cache_max_bytes":     c.CacheMaxBytes,
+		"history_max_entries": int64(c.HistoryMaxEntries),
+	} {
+		if n < 0 {
+			fail(key, "must not be negative, got %d", n)
+		}
+	}
+	if c.MaxBatchSize <= 0 {
+		fail("max_batch_size", "must be positive, got %d", c.MaxBatchSize)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	sort.Strings(errs)
+	return errors.New(strings.Join(errs, "; "))
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes config as YAML, secrets are redacted
+func (c *Config) Print(w io.Writer) error {
+	out, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// ParseConfig builds config from defaults, config file given by -config flag,
+// env vars and flags registered with registerConfigFlags, in that order
+func ParseConfig(fs *flag.FlagSet) (*Config, error) {
+	var config Config
+
+	// envconfig fills in both env vars and defaults, so it's the base layer
+	// and file values are applied only where env var is not set
+	if err := envconfig.Process(envPrefix, &config); err != nil {
+		return nil, err
+	}
+	if err := applySecretFiles(&config); err != nil {
+		return nil, err
+	}
+
+	if path := fs.Lookup("config").Value.String(); path != "" {
+		if err := applyFile(&config, path); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		field, ok := configField(&config, f.Name)
+		if ok && err == nil {
+			if setErr := setField(field, f.Value.String()); setErr != nil {
+				err = fmt.Errorf("flag -%s: %v", f.Name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// applyFile sets values from YAML file on config fields not set by env vars
+func applyFile(config *Config, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+
+	fields := map[string]reflect.Value{}
+	dst := reflect.ValueOf(config).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		if envSet(field) {
+			fields[field.Tag.Get("yaml")] = reflect.Value{}
+			continue
+		}
+		fields[field.Tag.Get("yaml")] = dst.Field(i)
+	}
+
+	for key, value := range values {
+		field, ok := fields[key]
+		if !ok {
+			return fmt.Errorf("config file %s: %s: unknown key", path, key)
+		}
+		if !field.IsValid() {
+			continue
+		}
+		// each value is decoded on its own, so errors can name the key
+		raw, err := yaml.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("config file %s: %s: %v", path, key, err)
+		}
+		parsed := reflect.New(field.Type())
+		if err := yaml.UnmarshalStrict(raw, parsed.Interface()); err != nil {
+			return fmt.Errorf("config file %s: %s: invalid value for %s", path, key, field.Type())
+		}
+		field.Set(parsed.Elem())
+	}
+	return nil
+}
+
+// applySecretFiles reads secrets from files given by env vars with _FILE
+// suffix, as mounted by Docker or Kubernetes. Lists are comma or newline separated.
+func applySecretFiles(config *Config) error {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if !isSecret(field.Type) {
+			continue
+		}
+		path, ok := os.LookupEnv(envKey(field) + "_FILE")
+		if !ok {
+			continue
+		}
+		if _, ok := os.LookupEnv(envKey(field)); ok {
+			return fmt.Errorf("%s and %s_FILE can't be both set", envKey(field), envKey(field))
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %v", envKey(field), err)
+		}
+		value := strings.TrimSpace(string(data))
+		if field.Type.Kind() == reflect.Slice {
+			value = strings.Replace(value, "\n", ",", -1)
+		}
+		if err := setField(v.Field(i), value); err != nil {
+			return fmt.Errorf("%s_FILE: %v", envKey(field), err)
+		}
+	}
+	return nil
+}
+
+var secretType = reflect.TypeOf(weather.Secret(""))
+
+func isSecret(t reflect.Type) bool {
+	return t == secretType || (t.Kind() == reflect.Slice && t.Elem() == secretType)
+}
+
+// envSet tells if the field is set by env var, directly or with a file
+func envSet(field reflect.StructField) bool {
+	if _, ok := os.LookupEnv(envKey(field)); ok {
+		return true
+	}
+	if !isSecret(field.Type) {
+		return false
+	}
+	_, ok := os.LookupEnv(envKey(field) + "_FILE")
+	return ok
+}
+
+// envKey returns env var name used by envconfig for the field
+func envKey(field reflect.StructField) string {
+	return strings.ToUpper(envPrefix + "_" + field.Name)
+}
+
+// flagName turns yaml key into a flag name, like cache_ttl into cache-ttl
+func flagName(field reflect.StructField) string {
+	return strings.Replace(field.Tag.Get("yaml"), "_", "-", -1)
+}
+
+func configField(config *Config, name string) (reflect.Value, bool) {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if flagName(v.Type().Field(i)) == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// rawFlag keeps flag value as given, it's parsed when config is built
+type rawFlag struct {
+	value  string
+	isBool bool
+}
+
+// IsBoolFlag lets bool fields be set with just -name
+func (f *rawFlag) IsBoolFlag() bool {
+	return f.isBool
+}
+
+func (f *rawFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.value
+}
+
+func (f *rawFlag) Set(value string) error {
+	f.value = value
+	return nil
+}
+
+// registerConfigFlags adds a flag for every config field
+func registerConfigFlags(fs *flag.FlagSet) {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		usage := field.Tag.Get("desc")
+		if usage == "" {
+			usage = "overrides " + envKey(field)
+		}
+		fs.Var(&rawFlag{isBool: field.Type.Kind() == reflect.Bool}, flagName(field), usage)
+	}
+}
+
+// setField parses value into the config field, lists are comma-separated
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+		return nil
+	case reflect.Slice:
+		items := reflect.MakeSlice(field.Type(), 0, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = reflect.Append(items, reflect.ValueOf(item).Convert(field.Type().Elem()))
+			}
+		}
+		field.Set(items)
+		return nil
+	}
+
+	parsed := reflect.New(field.Type())
+	if err := yaml.UnmarshalStrict([]byte(value), parsed.Interface()); err != nil {
+		return fmt.Errorf("invalid value %q for %s", value, field.Type())
+	}
+	field.Set(parsed.Elem())
+	return nil
+}
+
+// formatField is the opposite of setField, secrets are returned as they are
+func formatField(field reflect.Value) string {
+	switch field.Kind() {
+	case reflect.String:
+		return field.String()
+	case reflect.Slice:
+		items := make([]string, 0, field.Len())
+		for i := 0; i < field.Len(); i++ {
+			items = append(items, formatField(field.Index(i)))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(field.Interface())
+}
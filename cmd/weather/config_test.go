@@ -0,0 +1,445 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/stretchr/testify/assert"
+)
+
+// parseConfig runs ParseConfig with given flags, like main does
+func parseConfig(t *testing.T, args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	registerConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return ParseConfig(fs)
+}
+
+// setenv sets env vars for the test, t.Setenv isn't available in go 1.14
+func setenv(t *testing.T, env map[string]string) {
+	for key, value := range env {
+		old, ok := os.LookupEnv(key)
+		os.Setenv(key, value)
+		key := key
+		t.Cleanup(func() {
+			if ok {
+				os.Setenv(key, old)
+			} else {
+				os.Unsetenv(key)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	dir, err := ioutil.TempDir("", "weather-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConfig_Precedence(t *testing.T) {
+	file := writeFile(t, "config.yaml", "cache_ttl: 1h\nlisten: file:5555\n")
+
+	tests := []struct {
+		name       string
+		file       bool
+		env        map[string]string
+		args       []string
+		wantTTL    time.Duration
+		wantListen string
+	}{
+		{
+			name:       "Defaults",
+			wantTTL:    5 * time.Hour,
+			wantListen: "localhost:5555",
+		},
+		{
+			name:       "File overrides defaults",
+			file:       true,
+			wantTTL:    time.Hour,
+			wantListen: "file:5555",
+		},
+		{
+			name:       "Env overrides file",
+			file:       true,
+			env:        map[string]string{"WEATHER_CACHETTL": "2h"},
+			wantTTL:    2 * time.Hour,
+			wantListen: "file:5555",
+		},
+		{
+			name:       "Flag overrides env",
+			file:       true,
+			env:        map[string]string{"WEATHER_CACHETTL": "2h", "WEATHER_LISTEN": "env:5555"},
+			args:       []string{"-cache-ttl", "3h"},
+			wantTTL:    3 * time.Hour,
+			wantListen: "env:5555",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setenv(t, tt.env)
+			args := append([]string{"-weather-src-api-key", "key"}, tt.args...)
+			if tt.file {
+				args = append(args, "-config", file)
+			}
+
+			config, err := parseConfig(t, args...)
+
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantTTL, config.CacheTTL)
+			assert.Equal(t, tt.wantListen, config.Listen)
+		})
+	}
+}
+
+func TestParseConfig_File(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    func(config *Config)
+		wantErr string
+	}{
+		{
+			name:    "Lists and durations",
+			content: "external: [file, synthetic]\nfile_dir: testdata\nstorage_ttl: 90m\nsynthetic_error_rate: 0.5\n",
+			want: func(config *Config) {
+				config.External = []string{"file", "synthetic"}
+				config.FileDir = "testdata"
+				config.StorageTTL = 90 * time.Minute
+				config.SyntheticErrorRate = 0.5
+			},
+		},
+		{
+			name:    "Secrets list",
+			content: "weather_src_api_keys:\n  - a\n  - b\n",
+			want: func(config *Config) {
+				config.WeatherSrcAPIKeys = []weather.Secret{"a", "b"}
+			},
+		},
+		{
+			name:    "Unknown key",
+			content: "cache_tl: 1h\n",
+			wantErr: "config file %s: cache_tl: unknown key",
+		},
+		{
+			name:    "Invalid duration",
+			content: "weather_src_api_key: key\ncache_ttl: soon\n",
+			wantErr: "config file %s: cache_ttl: invalid value for time.Duration",
+		},
+		{
+			name:    "Invalid list",
+			content: "weather_src_api_key: key\nexternal: openweather\n",
+			wantErr: "config file %s: external: invalid value for []string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeFile(t, "config.yaml", tt.content)
+
+			config, err := parseConfig(t, "-config", path)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, fmt.Sprintf(tt.wantErr, path))
+				return
+			}
+			assert.Nil(t, err)
+			want := defaultConfig(t)
+			tt.want(want)
+			assert.Equal(t, want, config)
+		})
+	}
+
+	_, err := parseConfig(t, "-config", "missing.yaml")
+	assert.EqualError(t, err, "reading config file: open missing.yaml: no such file or directory")
+}
+
+func TestParseConfig_Flags(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		want    func(config *Config)
+		wantErr string
+	}{
+		{
+			name: "Bool flag without value",
+			args: []string{"-offline", "-file-dir", "testdata"},
+			want: func(config *Config) {
+				config.Offline = true
+				config.FileDir = "testdata"
+			},
+		},
+		{
+			name: "Bool flag set to false",
+			args: []string{"-offline=false", "-weather-src-api-key", "key"},
+			want: func(config *Config) {
+				config.WeatherSrcAPIKey = "key"
+			},
+		},
+		{
+			name: "Comma-separated lists",
+			args: []string{"-external", "synthetic, file,", "-file-dir", "testdata", "-weather-src-api-keys", "a,b"},
+			want: func(config *Config) {
+				config.External = []string{"synthetic", "file"}
+				config.FileDir = "testdata"
+				config.WeatherSrcAPIKeys = []weather.Secret{"a", "b"}
+			},
+		},
+		{
+			name: "Numbers and durations",
+			args: []string{"-weather-src-api-key", "key", "-cache-ttl", "1h30m", "-cache-max-bytes", "1024", "-chaos-error-rate", "0.25"},
+			want: func(config *Config) {
+				config.WeatherSrcAPIKey = "key"
+				config.CacheTTL = 90 * time.Minute
+				config.CacheMaxBytes = 1024
+				config.ChaosErrorRate = 0.25
+			},
+		},
+		{
+			name:    "Invalid duration",
+			args:    []string{"-weather-src-api-key", "key", "-cache-ttl", "soon"},
+			wantErr: `flag -cache-ttl: invalid value "soon" for time.Duration`,
+		},
+		{
+			name:    "Invalid bool",
+			args:    []string{"-offline=maybe"},
+			wantErr: `flag -offline: invalid value "maybe" for bool`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseConfig(t, tt.args...)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			want := defaultConfig(t)
+			tt.want(want)
+			assert.Equal(t, want, config)
+		})
+	}
+}
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(config *Config)
+		wantErr string
+	}{
+		{
+			name:   "Valid",
+			modify: func(config *Config) {},
+		},
+		{
+			name: "Missing API key",
+			modify: func(config *Config) {
+				config.WeatherSrcAPIKey = ""
+			},
+			wantErr: "weather_src_api_key: either weather_src_api_key or weather_src_api_keys is required",
+		},
+		{
+			name: "API key not needed offline",
+			modify: func(config *Config) {
+				config.WeatherSrcAPIKey = ""
+				config.Offline = true
+				config.FileDir = "testdata"
+			},
+		},
+		{
+			name: "Relative API URL",
+			modify: func(config *Config) {
+				config.WeatherSrcAPIURL = "/weather"
+			},
+			wantErr: `weather_src_api_url: "/weather" is not an absolute URL`,
+		},
+		{
+			name: "Unknown providers",
+			modify: func(config *Config) {
+				config.External = []string{"openweather", "missing"}
+				config.Storage = []string{"tape"}
+			},
+			wantErr: `external: unknown provider "missing", available: file, openweather, synthetic; ` +
+				`storage: unknown provider "tape", available: disk, memory`,
+		},
+		{
+			name: "No external providers",
+			modify: func(config *Config) {
+				config.External = nil
+			},
+			wantErr: "external: at least one provider is required",
+		},
+		{
+			name: "Missing dirs",
+			modify: func(config *Config) {
+				config.Offline = true
+				config.Storage = []string{"memory", "disk"}
+			},
+			wantErr: "file_dir: is required by file provider; storage_dir: is required by disk storage",
+		},
+		{
+			name: "Out of range values",
+			modify: func(config *Config) {
+				config.Listen = ""
+				config.ChaosCorruptRate = 1.5
+				config.SyntheticErrorRate = -0.1
+				config.StorageTTL = -time.Second
+				config.CacheMaxEntries = -1
+				config.MaxBatchSize = 0
+			},
+			wantErr: "cache_max_entries: must not be negative, got -1; " +
+				"chaos_corrupt_rate: must be between 0 and 1, got 1.5; " +
+				"listen: must not be empty; " +
+				"max_batch_size: must be positive, got 0; " +
+				"storage_ttl: must not be negative, got -1s; " +
+				"synthetic_error_rate: must be between 0 and 1, got -0.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig(t)
+			config.WeatherSrcAPIKey = "key"
+			tt.modify(config)
+
+			err := config.Validate()
+
+			if tt.wantErr == "" {
+				assert.Nil(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+// TestConfigKeys checks that settings every provider reads exist in config
+func TestConfigKeys(t *testing.T) {
+	for _, name := range weathersrc.ExternalProviders() {
+		for setting, key := range weathersrc.ExternalConfigKeys(name) {
+			_, ok := configField(&Config{}, strings.Replace(key, "_", "-", -1))
+			assert.True(t, ok, "external %s: %s reads unknown config key %s", name, setting, key)
+		}
+	}
+	for _, name := range weathersrc.StorageProviders() {
+		for setting, key := range weathersrc.StorageConfigKeys(name) {
+			_, ok := configField(&Config{}, strings.Replace(key, "_", "-", -1))
+			assert.True(t, ok, "storage %s: %s reads unknown config key %s", name, setting, key)
+		}
+	}
+}
+
+// defaultConfig returns config with only defaults applied, tests don't set
+// WEATHER_* env vars around it
+func defaultConfig(t *testing.T) *Config {
+	config := &Config{}
+	if err := envconfig.Process(envPrefix, config); err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func TestConfig_Print(t *testing.T) {
+	config := defaultConfig(t)
+	config.WeatherSrcAPIKey = "api-key"
+	config.WeatherSrcAPIKeys = []weather.Secret{"key1", "key2"}
+	config.AdminToken = "s3cr3t"
+
+	var out strings.Builder
+	assert.Nil(t, config.Print(&out))
+
+	for _, secret := range []string{"api-key", "key1", "key2", "s3cr3t"} {
+		assert.NotContains(t, out.String(), secret)
+		assert.NotContains(t, fmt.Sprintf("%v", config), secret)
+		assert.NotContains(t, fmt.Sprintf("%+v", *config), secret)
+	}
+	assert.Contains(t, out.String(), "weather_src_api_key: '[redacted]'\n")
+	assert.Contains(t, out.String(), "weather_src_api_keys:\n- '[redacted]'\n- '[redacted]'\n")
+	assert.Contains(t, out.String(), "admin_token: '[redacted]'\n")
+	assert.Contains(t, out.String(), "cache_ttl: 5h0m0s\n")
+}
+
+func TestParseConfig_SecretFiles(t *testing.T) {
+	key := writeFile(t, "key", "api-key\n")
+	keys := writeFile(t, "keys", "key1\nkey2\n\nkey3,key4\n")
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		file     string
+		wantKey  weather.Secret
+		wantKeys []weather.Secret
+		wantErr  string
+	}{
+		{
+			name:    "Single secret, trailing newline is trimmed",
+			env:     map[string]string{"WEATHER_WEATHERSRCAPIKEY_FILE": key},
+			wantKey: "api-key",
+		},
+		{
+			name:     "List separated by newlines or commas",
+			env:      map[string]string{"WEATHER_WEATHERSRCAPIKEYS_FILE": keys},
+			wantKeys: []weather.Secret{"key1", "key2", "key3", "key4"},
+		},
+		{
+			name:    "File takes precedence over config file",
+			env:     map[string]string{"WEATHER_WEATHERSRCAPIKEY_FILE": key},
+			file:    "weather_src_api_key: from-config\n",
+			wantKey: "api-key",
+		},
+		{
+			name: "Both env var and file",
+			env: map[string]string{
+				"WEATHER_WEATHERSRCAPIKEY":      "api-key",
+				"WEATHER_WEATHERSRCAPIKEY_FILE": key,
+			},
+			wantErr: "WEATHER_WEATHERSRCAPIKEY and WEATHER_WEATHERSRCAPIKEY_FILE can't be both set",
+		},
+		{
+			name:    "Missing file",
+			env:     map[string]string{"WEATHER_ADMINTOKEN_FILE": "missing"},
+			wantErr: "WEATHER_ADMINTOKEN_FILE: open missing: no such file or directory",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.file != "" {
+				args = append(args, "-config", writeFile(t, "config.yaml", tt.file))
+			}
+			setenv(t, tt.env)
+
+			config, err := parseConfig(t, args...)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantKey, config.WeatherSrcAPIKey)
+			assert.Equal(t, tt.wantKeys, config.WeatherSrcAPIKeys)
+		})
+	}
+}
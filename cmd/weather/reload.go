@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/papisz/weather/weathersrc/cache"
+	"github.com/papisz/weather/weathersrc/disk"
+	"github.com/papisz/weather/weathersrc/openweather"
+)
+
+// configPollInterval is how often config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// reloadable lists config keys applied to running instances, changing any
+// other key requires a restart
+var reloadable = map[string]bool{
+	"weather_src_api_key":         true,
+	"weather_src_api_keys":        true,
+	"cache_ttl":                   true,
+	"cache_max_entries":           true,
+	"cache_max_bytes":             true,
+	"storage_ttl":                 true,
+	"storage_compaction_interval": true,
+}
+
+// reloader applies changed config to running instances. Instances guard
+// their own state, so settings can be changed while requests are served.
+type reloader struct {
+	mu        sync.Mutex
+	config    *Config
+	providers *providers
+}
+
+func (r *reloader) apply(config *Config) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := changedKeys(r.config, config)
+	if len(changed) == 0 {
+		return
+	}
+
+	for _, key := range changed {
+		if !reloadable[key] {
+			log.Printf("config reload: %s changed, restart is required to apply it", key)
+		}
+	}
+
+	for _, external := range r.providers.externals {
+		if p, ok := external.(*openweather.OpenWeatherSrc); ok {
+			p.SetAPIKeys(config.APIKeys()...)
+		}
+	}
+	for _, storage := range r.providers.storages {
+		switch p := storage.(type) {
+		case *cache.CacheWeatherSrc:
+			p.SetTTL(config.CacheTTL)
+			p.SetLimits(config.CacheMaxEntries, config.CacheMaxBytes)
+		case *disk.DiskWeatherSrc:
+			// compaction interval follows storage TTL unless it's set, like
+			// when storage is built
+			p.SetTTL(config.StorageTTL)
+			interval := config.StorageCompactionInterval
+			if interval == 0 {
+				interval = config.StorageTTL
+			}
+			p.SetCompactionInterval(interval)
+		}
+	}
+
+	// keep keys which need a restart as they are, so they are reported
+	// again only if they change once more
+	next := *config
+	current := reflect.ValueOf(r.config).Elem()
+	v := reflect.ValueOf(&next).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !reloadable[v.Type().Field(i).Tag.Get("yaml")] {
+			v.Field(i).Set(current.Field(i))
+		}
+	}
+	r.config = &next
+	log.Printf("config reloaded, changed: %v", changed)
+}
+
+// changedKeys returns yaml keys of fields which differ
+func changedKeys(old, new *Config) []string {
+	var keys []string
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < o.NumField(); i++ {
+		if !reflect.DeepEqual(o.Field(i).Interface(), n.Field(i).Interface()) {
+			keys = append(keys, o.Type().Field(i).Tag.Get("yaml"))
+		}
+	}
+	return keys
+}
+
+// watchConfig reloads config on SIGHUP and when config file changes, which is
+// checked every interval, until stop is closed. Invalid config is logged and
+// ignored, running instances keep the previous one.
+func watchConfig(fs *flag.FlagSet, r *reloader, interval time.Duration, stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	path := fs.Lookup("config").Value.String()
+	lastMod := modTime(path)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-hup:
+		case <-ticker.C:
+			if path == "" {
+				continue
+			}
+			mod := modTime(path)
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+		}
+
+		config, err := ParseConfig(fs)
+		if err != nil {
+			log.Printf("config reload failed, keeping previous config: %v", err)
+			continue
+		}
+		r.apply(config)
+	}
+}
+
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
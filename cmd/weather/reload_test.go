@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/cache"
+	"github.com/papisz/weather/weathersrc/disk"
+	"github.com/papisz/weather/weathersrc/openweather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangedKeys(t *testing.T) {
+	old := defaultConfig(t)
+
+	tests := []struct {
+		name   string
+		modify func(config *Config)
+		want   []string
+	}{
+		{
+			name:   "Nothing changed",
+			modify: func(config *Config) {},
+		},
+		{
+			name: "Secrets and lists",
+			modify: func(config *Config) {
+				config.WeatherSrcAPIKeys = []weather.Secret{"a"}
+				config.External = []string{"openweather", "file"}
+			},
+			want: []string{"weather_src_api_keys", "external"},
+		},
+		{
+			name: "Keys in field order",
+			modify: func(config *Config) {
+				config.MaxBatchSize = 10
+				config.Listen = "localhost:80"
+				config.CacheTTL = time.Minute
+			},
+			want: []string{"listen", "cache_ttl", "max_batch_size"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig(t)
+			tt.modify(config)
+
+			assert.Equal(t, tt.want, changedKeys(old, config))
+		})
+	}
+}
+
+func TestReloader_Apply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2020, 4, 27, 12, 0, 0, 0, time.UTC)
+	ow := openweather.NewWeatherSrc(openweather.WithAPIKey("key-one"))
+	memory := cache.NewWeatherSrc(cache.WithTTL(time.Hour), cache.WithClock(func() time.Time { return now }))
+	storage := disk.NewWeatherSrc(disk.WithDirPath(dir), disk.WithTTL(time.Hour), disk.WithCompactionInterval(time.Hour))
+	defer storage.Close()
+
+	config := defaultConfig(t)
+	config.WeatherSrcAPIKey = "key-one"
+	r := &reloader{config: config, providers: &providers{
+		externals: []weathersrc.ForecastProvider{ow},
+		storages:  []weathersrc.WriteableForecastProvider{memory, storage},
+	}}
+	for _, city := range []string{"London", "Warsaw", "Paris"} {
+		assert.Nil(t, memory.SaveForecast(city, testutils.ForecastFromJSON("london.json")))
+	}
+
+	next := *config
+	next.WeatherSrcAPIKeys = []weather.Secret{"key-two", "key-three"}
+	next.CacheTTL = time.Minute
+	next.CacheMaxEntries = 1
+	next.StorageTTL = 2 * time.Minute
+	next.Listen = "localhost:80"
+	r.apply(&next)
+
+	assert.Len(t, ow.KeyStatuses(), 3)
+	assert.Equal(t, 1, memory.Stats().Entries)
+
+	assert.Nil(t, memory.SaveForecast("Berlin", testutils.ForecastFromJSON("london.json")))
+	entry, err := memory.GetEntry("Berlin")
+	assert.Nil(t, err)
+	assert.Equal(t, now.Add(time.Minute), entry.ExpiresAt)
+
+	assert.Nil(t, storage.SaveForecast("Berlin", testutils.ForecastFromJSON("london.json")))
+	entry, err = storage.GetEntry("Berlin")
+	assert.Nil(t, err)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), entry.ExpiresAt, time.Minute)
+
+	// keys which need a restart keep their running values
+	assert.Equal(t, time.Minute, r.config.CacheTTL)
+	assert.Equal(t, "localhost:5555", r.config.Listen)
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := writeFile(t, "config.yaml", "weather_src_api_key: key-one\ncache_max_entries: 3\n")
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("config", "", "")
+	registerConfigFlags(fs)
+	assert.Nil(t, fs.Parse([]string{"-config", path}))
+
+	config, err := ParseConfig(fs)
+	assert.Nil(t, err)
+	memory := cache.NewWeatherSrc(cache.WithMaxEntries(config.CacheMaxEntries))
+	for _, city := range []string{"London", "Warsaw", "Paris"} {
+		assert.Nil(t, memory.SaveForecast(city, testutils.ForecastFromJSON("london.json")))
+	}
+	r := &reloader{config: config, providers: &providers{
+		storages: []weathersrc.WriteableForecastProvider{memory},
+	}}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		watchConfig(fs, r, 10*time.Millisecond, stop)
+	}()
+
+	// invalid config is ignored
+	modified := time.Now().Add(time.Minute)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("cache_max_entries: -1\n"), 0600))
+	assert.Nil(t, os.Chtimes(path, modified, modified))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, memory.Stats().Entries)
+
+	modified = modified.Add(time.Minute)
+	assert.Nil(t, ioutil.WriteFile(path, []byte("weather_src_api_key: key-one\ncache_max_entries: 1\n"), 0600))
+	assert.Nil(t, os.Chtimes(path, modified, modified))
+	assert.Eventually(t, func() bool {
+		return memory.Stats().Entries == 1
+	}, time.Second, 10*time.Millisecond)
+
+	close(stop)
+	wg.Wait()
+}
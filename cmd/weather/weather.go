@@ -2,75 +2,175 @@ package main
 
 import (
 	"flag"
-	"time"
+	"fmt"
+	"os"
+	"strings"
 
 	"log"
 
-	"github.com/kelseyhightower/envconfig"
-	"github.com/papisz/weather/api/http"
+	"github.com/papisz/weather/history"
+	"github.com/papisz/weather/history/memory"
+	"github.com/papisz/weather/history/sqlite"
 	"github.com/papisz/weather/weathersrc"
-	"github.com/papisz/weather/weathersrc/cache"
-	"github.com/papisz/weather/weathersrc/openweather"
-	"gopkg.in/relistan/rubberneck.v1"
+	"github.com/papisz/weather/weathersrc/chain"
+	"github.com/papisz/weather/weathersrc/chaos"
+	"github.com/papisz/weather/weathersrc/tiered"
+
+	"github.com/papisz/weather/weathersrc/file"
+
+	// providers registering themselves
+	_ "github.com/papisz/weather/weathersrc/synthetic"
 )
 
-type Config struct {
-	Listen           string        `default:"localhost:5555"`
-	WeatherSrcAPIKey string        `required:"true"`
-	WeatherSrcAPIURL string        `default:"https://api.openweathermap.org/data/2.5/weather"`
-	CacheTTL         time.Duration `default:"5h"`
+// providers are built from registry by names given in config
+type providers struct {
+	// external asks externals in order, recording them if configured
+	external  weathersrc.ForecastProvider
+	externals []weathersrc.ForecastProvider
+	// storage layers storages, from the fastest
+	storage  weathersrc.WriteableForecastProvider
+	storages []weathersrc.WriteableForecastProvider
 }
 
-func ParseConfig() *Config {
-	help := flag.Bool("help", false, "print help")
-	flag.Parse()
+// settingsFor reads settings from config keys provider declared
+func settingsFor(config *Config, keys weathersrc.ConfigKeys) weathersrc.Settings {
+	settings := weathersrc.Settings{}
+	for setting, key := range keys {
+		field, ok := configField(config, strings.Replace(key, "_", "-", -1))
+		if !ok {
+			log.Printf("setting %s is read from unknown config key %s", setting, key)
+			continue
+		}
+		settings[setting] = formatField(field)
+	}
+	return settings
+}
 
-	var config Config
+func newProviders(config *Config) (*providers, error) {
+	p := &providers{}
 
-	if help != nil && *help {
-		if err := envconfig.Usage("weather", &config); err != nil {
-			log.Fatal(err)
+	var links []weathersrc.ForecastProvider
+	for _, name := range config.ExternalChain() {
+		external, err := weathersrc.NewExternal(name, settingsFor(config, weathersrc.ExternalConfigKeys(name)))
+		if err != nil {
+			return nil, err
+		}
+		p.externals = append(p.externals, external)
+
+		// fixtures aren't recorded again
+		if config.RecordDir != "" && name != file.Source {
+			external = file.NewRecorder(external, config.RecordDir)
+		}
+		links = append(links, external)
+	}
+	p.external = links[0]
+	if len(links) > 1 {
+		var opts []chain.Option
+		for _, link := range links {
+			opts = append(opts, chain.WithProvider(link))
 		}
-		return nil
+		p.external = chain.NewWeatherSrc(opts...)
 	}
 
-	if err := envconfig.Process("weather", &config); err != nil {
-		log.Fatalf("invalid config, %v", err)
-		envconfig.Usage("weather", &config)
-		return nil
+	p.external = withChaos(config, p.external)
+
+	var tiers []tiered.Option
+	for _, name := range config.StorageTiers() {
+		storage, err := weathersrc.NewStorage(name, settingsFor(config, weathersrc.StorageConfigKeys(name)))
+		if err != nil {
+			return nil, err
+		}
+		p.storages = append(p.storages, storage)
+		tiers = append(tiers, tiered.WithTier(storage))
+	}
+	p.storage = p.storages[0]
+	if len(p.storages) > 1 {
+		p.storage = tiered.NewWeatherSrc(tiers...)
+	}
+
+	return p, nil
+}
+
+// withChaos wraps external provider with fault injection if it's configured
+func withChaos(config *Config, external weathersrc.ForecastProvider) weathersrc.ForecastProvider {
+	if config.ChaosErrorRate == 0 && config.ChaosLatencyRate == 0 && config.ChaosCorruptRate == 0 {
+		return external
+	}
+
+	log.Printf("injecting faults into external provider calls")
+	return chaos.NewWeatherSrc(external,
+		chaos.WithErrors(config.ChaosErrorRate, chaos.ErrTooManyRequests, chaos.ErrTimeout, chaos.ErrMisconfigured),
+		chaos.WithDelay(config.ChaosLatencyRate, config.ChaosLatency),
+		chaos.WithCorruption(config.ChaosCorruptRate),
+	)
+}
+
+// newHistoryStore returns SQLite store if history_db is set, in-memory one otherwise
+func newHistoryStore(config *Config) (history.Store, error) {
+	if config.HistoryDB != "" {
+		return sqlite.Open(config.HistoryDB,
+			sqlite.WithMaxAge(config.HistoryMaxAge),
+			sqlite.WithMaxEntries(config.HistoryMaxEntries),
+		)
 	}
+	return memory.NewStore(
+		memory.WithMaxAge(config.HistoryMaxAge),
+		memory.WithMaxEntries(config.HistoryMaxEntries),
+	), nil
+}
 
-	rubberneck.Print(config)
-	return &config
+func newManager(external weathersrc.ForecastProvider, storage weathersrc.WriteableForecastProvider, historyStore history.Store) *weathersrc.ForecastManagerImpl {
+	return weathersrc.NewForecastManager(
+		weathersrc.WithExternalProvider(external),
+		weathersrc.WithStorageProvider(storage),
+		weathersrc.WithHistoryStore(historyStore),
+	)
 }
 
 func main() {
-	config := ParseConfig()
+	help := flag.Bool("help", false, "print help")
+	printConfig := flag.Bool("print-config", false, "print effective config with secrets redacted and exit")
+	flag.String("config", os.Getenv("WEATHER_CONFIG"), "path to YAML config file, WEATHER_CONFIG by default")
+	registerConfigFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
+	if help != nil && *help {
+		usage()
+		return
+	}
+
+	config, err := ParseConfig(flag.CommandLine)
+	if err != nil {
+		log.Fatalf("invalid config, %v", err)
+	}
 
-	if config == nil {
+	if *printConfig {
+		if err := config.Print(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
-	if err := http.NewApi(
-		http.WithListenAddress(config.Listen),
-		http.WithForecastManager(
-			weathersrc.NewForecastManager(
-				weathersrc.WithExternalProvider(
-					openweather.NewWeatherSrc(
-						openweather.WithURL(config.WeatherSrcAPIURL),
-						openweather.WithAPIKey(config.WeatherSrcAPIKey),
-						openweather.WithDefaultClient(),
-					),
-				),
-				weathersrc.WithStorageProvider(
-					cache.NewWeatherSrc(
-						cache.WithTTL(config.CacheTTL),
-					),
-				),
-			),
-		),
-	).Serve(); err != nil {
-		log.Fatalf("error starting server: %v", err)
+	command, args := "serve", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
 	}
 
+	switch command {
+	case "serve":
+		err = serve(config)
+	case "get":
+		err = get(os.Stdout, config, args)
+	case "cache":
+		err = cacheCommand(os.Stdout, config, args)
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.Fatalf("%s: %v", command, err)
+	}
 }
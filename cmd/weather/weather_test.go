@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithChaos(t *testing.T) {
+	upstream := providertest.Func(func(city string) (*weather.Forecast, error) {
+		return &weather.Forecast{Name: city}, nil
+	})
+
+	tests := []struct {
+		name      string
+		errorRate float64
+		wantFails int
+	}{
+		{name: "No errors", errorRate: 0, wantFails: 0},
+		{name: "Every call fails", errorRate: 1, wantFails: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := defaultConfig(t)
+			config.ChaosErrorRate = tt.errorRate
+
+			p := withChaos(config, upstream)
+			fails := 0
+			for i := 0; i < 100; i++ {
+				if _, err := p.GetForecast("London"); err != nil {
+					fails++
+				}
+			}
+			assert.Equal(t, tt.wantFails, fails)
+		})
+	}
+}
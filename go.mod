@@ -6,11 +6,12 @@ require (
 	github.com/go-chi/chi v4.1.1+incompatible
 	github.com/go-chi/render v1.0.1
 	github.com/golang/mock v1.4.3
-	github.com/google/go-cmp v0.4.0
+	github.com/google/go-cmp v0.5.0
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/kylelemons/godebug v1.1.0 // indirect
-	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/mattn/go-sqlite3 v1.14.6
 	github.com/stretchr/testify v1.5.1
 	github.com/vektra/mockery v0.0.0-20181123154057-e78b021dcbb5 // indirect
-	gopkg.in/relistan/rubberneck.v1 v1.1.0
+	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v2 v2.2.2
 )
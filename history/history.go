@@ -0,0 +1,15 @@
+package history
+
+import (
+	"time"
+
+	"github.com/papisz/weather"
+)
+
+// Store keeps every fetched forecast, keyed by city and forecast time (Dt).
+// Appending a forecast with the same city and Dt replaces the previous one.
+type Store interface {
+	Append(city string, forecast *weather.Forecast) error
+	// Query returns forecasts for city with Dt in [from, to], ordered by Dt
+	Query(city string, from, to time.Time) ([]*weather.Forecast, error)
+}
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/papisz/weather"
+)
+
+// MemoryStore is a history store keeping forecasts in memory
+type MemoryStore struct {
+	mu         sync.RWMutex
+	cities     map[string][]*weather.Forecast
+	maxAge     time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+type Option func(store *MemoryStore)
+
+func NewStore(opts ...Option) *MemoryStore {
+	store := &MemoryStore{
+		cities: map[string][]*weather.Forecast{},
+		now:    time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	return store
+}
+
+// WithMaxAge drops forecasts older than given duration
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(store *MemoryStore) {
+		store.maxAge = maxAge
+	}
+}
+
+// WithMaxEntries limits number of forecasts kept per city, the oldest are dropped first
+func WithMaxEntries(maxEntries int) Option {
+	return func(store *MemoryStore) {
+		store.maxEntries = maxEntries
+	}
+}
+
+func (s *MemoryStore) Append(city string, forecast *weather.Forecast) error {
+	city = strings.ToLower(city)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	forecasts := s.cities[city]
+	i := sort.Search(len(forecasts), func(i int) bool { return forecasts[i].Dt >= forecast.Dt })
+	switch {
+	case i < len(forecasts) && forecasts[i].Dt == forecast.Dt:
+		forecasts[i] = forecast
+	default:
+		forecasts = append(forecasts, nil)
+		copy(forecasts[i+1:], forecasts[i:])
+		forecasts[i] = forecast
+	}
+
+	s.cities[city] = s.prune(forecasts)
+	return nil
+}
+
+func (s *MemoryStore) Query(city string, from, to time.Time) ([]*weather.Forecast, error) {
+	city = strings.ToLower(city)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	forecasts := s.cities[city]
+	start := sort.Search(len(forecasts), func(i int) bool { return int64(forecasts[i].Dt) >= from.Unix() })
+	end := sort.Search(len(forecasts), func(i int) bool { return int64(forecasts[i].Dt) > to.Unix() })
+	if start >= end {
+		return []*weather.Forecast{}, nil
+	}
+
+	result := make([]*weather.Forecast, end-start)
+	copy(result, forecasts[start:end])
+	return result, nil
+}
+
+// prune applies retention limits to forecasts sorted by Dt
+func (s *MemoryStore) prune(forecasts []*weather.Forecast) []*weather.Forecast {
+	if s.maxAge > 0 {
+		minDt := s.now().Add(-s.maxAge).Unix()
+		i := sort.Search(len(forecasts), func(i int) bool { return int64(forecasts[i].Dt) >= minDt })
+		forecasts = forecasts[i:]
+	}
+	if s.maxEntries > 0 && len(forecasts) > s.maxEntries {
+		forecasts = forecasts[len(forecasts)-s.maxEntries:]
+	}
+	return forecasts
+}
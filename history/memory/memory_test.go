@@ -0,0 +1,50 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore_AppendQuery(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	s := NewStore(WithMaxAge(time.Hour), WithMaxEntries(3))
+	s.now = func() time.Time { return now }
+
+	for _, dt := range []int{999000, 996000, 998000, 997000, 999500} {
+		assert.Nil(t, s.Append("London", forecastAt(dt, 10)))
+	}
+	// same Dt replaces previous forecast
+	assert.Nil(t, s.Append("london", forecastAt(998000, 20)))
+	assert.Nil(t, s.Append("Warsaw", forecastAt(999000, 30)))
+
+	got, err := s.Query("LONDON", time.Unix(0, 0), now)
+	assert.Nil(t, err)
+	assert.Equal(t, []*weather.Forecast{
+		forecastAt(998000, 20),
+		forecastAt(999000, 10),
+		forecastAt(999500, 10),
+	}, got)
+
+	got, err = s.Query("london", time.Unix(998500, 0), time.Unix(999000, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, []*weather.Forecast{forecastAt(999000, 10)}, got)
+
+	got, err = s.Query("szczebrzeszyn", time.Unix(0, 0), now)
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+
+	// forecasts older than max age are dropped on next append
+	now = now.Add(time.Hour)
+	assert.Nil(t, s.Append("london", forecastAt(1003000, 10)))
+	got, _ = s.Query("london", time.Unix(0, 0), now)
+	assert.Equal(t, []*weather.Forecast{forecastAt(1003000, 10)}, got)
+}
+
+func forecastAt(dt int, temp float64) *weather.Forecast {
+	f := &weather.Forecast{Dt: dt}
+	f.Main.Temp = temp
+	return f
+}
@@ -0,0 +1,155 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/papisz/weather"
+
+	// registers sqlite3 driver, it needs cgo
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `CREATE TABLE IF NOT EXISTS forecast_history (
+	city     TEXT    NOT NULL,
+	dt       INTEGER NOT NULL,
+	forecast TEXT    NOT NULL,
+	PRIMARY KEY (city, dt)
+)`
+
+// SQLiteStore is a history store keeping forecasts in SQLite database, so
+// they survive restarts.
+type SQLiteStore struct {
+	db         *sql.DB
+	maxAge     time.Duration
+	maxEntries int
+	now        func() time.Time
+}
+
+type Option func(store *SQLiteStore)
+
+// Open returns store keeping forecasts in database file at path, created if
+// it doesn't exist
+func Open(path string, opts ...Option) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open history database: %w", err)
+	}
+	// a single connection serializes writes, SQLite would lock them anyway
+	db.SetMaxOpenConns(1)
+
+	store, err := NewStore(db, opts...)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// NewStore returns store using given database. Table is created if it doesn't exist.
+func NewStore(db *sql.DB, opts ...Option) (*SQLiteStore, error) {
+	store := &SQLiteStore{
+		db:  db,
+		now: time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(store)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("unable to create history table: %w", err)
+	}
+	return store, nil
+}
+
+// WithMaxAge drops forecasts older than given duration
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(store *SQLiteStore) {
+		store.maxAge = maxAge
+	}
+}
+
+// WithMaxEntries limits number of forecasts kept per city, the oldest are dropped first
+func WithMaxEntries(maxEntries int) Option {
+	return func(store *SQLiteStore) {
+		store.maxEntries = maxEntries
+	}
+}
+
+func (s *SQLiteStore) Append(city string, forecast *weather.Forecast) error {
+	city = strings.ToLower(city)
+
+	jsonBytes, err := json.Marshal(forecast)
+	if err != nil {
+		return fmt.Errorf("unable to marshal forecast: %v", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		`INSERT OR REPLACE INTO forecast_history (city, dt, forecast) VALUES (?, ?, ?)`,
+		city, forecast.Dt, string(jsonBytes),
+	); err != nil {
+		return fmt.Errorf("unable to insert forecast: %w", err)
+	}
+
+	if s.maxAge > 0 {
+		if _, err := tx.Exec(
+			`DELETE FROM forecast_history WHERE city = ? AND dt < ?`,
+			city, s.now().Add(-s.maxAge).Unix(),
+		); err != nil {
+			return fmt.Errorf("unable to prune history: %w", err)
+		}
+	}
+	if s.maxEntries > 0 {
+		if _, err := tx.Exec(
+			`DELETE FROM forecast_history WHERE city = ? AND dt NOT IN (
+				SELECT dt FROM forecast_history WHERE city = ? ORDER BY dt DESC LIMIT ?
+			)`,
+			city, city, s.maxEntries,
+		); err != nil {
+			return fmt.Errorf("unable to prune history: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close closes the database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) Query(city string, from, to time.Time) ([]*weather.Forecast, error) {
+	rows, err := s.db.Query(
+		`SELECT forecast FROM forecast_history WHERE city = ? AND dt >= ? AND dt <= ? ORDER BY dt`,
+		strings.ToLower(city), from.Unix(), to.Unix(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query history: %w", err)
+	}
+	defer rows.Close()
+
+	forecasts := []*weather.Forecast{}
+	for rows.Next() {
+		var jsonString string
+		if err := rows.Scan(&jsonString); err != nil {
+			return nil, fmt.Errorf("unable to read history: %w", err)
+		}
+
+		forecast := &weather.Forecast{}
+		if err := json.Unmarshal([]byte(jsonString), forecast); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal bytes: %v", err)
+		}
+		forecasts = append(forecasts, forecast)
+	}
+	return forecasts, rows.Err()
+}
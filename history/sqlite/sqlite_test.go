@@ -0,0 +1,77 @@
+//go:build cgo
+// +build cgo
+
+package sqlite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSQLiteStore_AppendQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.db")
+
+	now := time.Unix(1000000, 0)
+	s, err := Open(path, WithMaxAge(time.Hour), WithMaxEntries(3))
+	if !assert.Nil(t, err) {
+		return
+	}
+	s.now = func() time.Time { return now }
+
+	for _, dt := range []int{999000, 996000, 998000, 997000, 999500} {
+		assert.Nil(t, s.Append("London", forecastAt(dt, 10)))
+	}
+	// same Dt replaces previous forecast
+	assert.Nil(t, s.Append("london", forecastAt(998000, 20)))
+	assert.Nil(t, s.Append("Warsaw", forecastAt(999000, 30)))
+
+	got, err := s.Query("LONDON", time.Unix(0, 0), now)
+	assert.Nil(t, err)
+	assert.Equal(t, []*weather.Forecast{
+		forecastAt(998000, 20),
+		forecastAt(999000, 10),
+		forecastAt(999500, 10),
+	}, got)
+
+	got, err = s.Query("london", time.Unix(998500, 0), time.Unix(999000, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, []*weather.Forecast{forecastAt(999000, 10)}, got)
+
+	got, err = s.Query("szczebrzeszyn", time.Unix(0, 0), now)
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+
+	// forecasts older than max age are dropped on next append
+	now = now.Add(time.Hour)
+	assert.Nil(t, s.Append("london", forecastAt(1003000, 10)))
+	got, _ = s.Query("london", time.Unix(0, 0), now)
+	assert.Equal(t, []*weather.Forecast{forecastAt(1003000, 10)}, got)
+
+	// history survives reopening
+	assert.Nil(t, s.Close())
+	s, err = Open(path)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer s.Close()
+	got, err = s.Query("warsaw", time.Unix(0, 0), now)
+	assert.Nil(t, err)
+	assert.Equal(t, []*weather.Forecast{forecastAt(999000, 30)}, got)
+}
+
+func forecastAt(dt int, temp float64) *weather.Forecast {
+	f := &weather.Forecast{Dt: dt}
+	f.Main.Temp = temp
+	return f
+}
@@ -0,0 +1,28 @@
+package weather
+
+// redacted replaces secret values wherever they are printed or encoded
+const redacted = "[redacted]"
+
+// Secret is a string, like an API key, which must not end up in logs. It's
+// redacted when formatted or encoded as JSON or YAML, use Value to read it.
+type Secret string
+
+// Value returns the secret itself
+func (s Secret) Value() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return `"` + s.String() + `"`
+}
+
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
@@ -0,0 +1,63 @@
+package weather
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret Secret
+		want   string
+	}{
+		{
+			name:   "Set",
+			secret: "api-key",
+			want:   "[redacted]",
+		},
+		{
+			name:   "Empty",
+			secret: "",
+			want:   "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.secret.String())
+			assert.Equal(t, `"`+tt.want+`"`, tt.secret.GoString())
+			text, err := tt.secret.MarshalText()
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, string(text))
+			assert.Equal(t, string(tt.secret), tt.secret.Value())
+		})
+	}
+}
+
+func TestSecret_Redacted(t *testing.T) {
+	config := struct {
+		Key  Secret   `json:"key" yaml:"key"`
+		Keys []Secret `json:"keys" yaml:"keys"`
+	}{
+		Key:  "api-key",
+		Keys: []Secret{"key1", "key2"},
+	}
+
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		assert.NotContains(t, fmt.Sprintf(format, config), "key1", format)
+		assert.NotContains(t, fmt.Sprintf(format, config), "api-key", format)
+	}
+	assert.Equal(t, "{[redacted] [[redacted] [redacted]]}", fmt.Sprintf("%v", config))
+
+	jsonBytes, err := json.Marshal(config)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"key": "[redacted]", "keys": ["[redacted]", "[redacted]"]}`, string(jsonBytes))
+
+	yamlBytes, err := yaml.Marshal(config)
+	assert.Nil(t, err)
+	assert.Equal(t, "key: '[redacted]'\nkeys:\n- '[redacted]'\n- '[redacted]'\n", string(yamlBytes))
+}
@@ -2,60 +2,152 @@ package weather
 
 import (
 	"errors"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 // Forecast describes weather conditions for one day in one city
 type Forecast struct {
 	Coord struct {
-		Lon float64 `json:"lon"`
-		Lat float64 `json:"lat"`
-	} `json:"coord"`
+		Lon float64 `json:"lon" xml:"lon"`
+		Lat float64 `json:"lat" xml:"lat"`
+	} `json:"coord" xml:"coord"`
 	Weather []struct {
-		ID          int    `json:"id"`
-		Main        string `json:"main"`
-		Description string `json:"description"`
-		Icon        string `json:"icon"`
-	} `json:"weather"`
-	Base string `json:"base"`
+		ID          int    `json:"id" xml:"id"`
+		Main        string `json:"main" xml:"main"`
+		Description string `json:"description" xml:"description"`
+		Icon        string `json:"icon" xml:"icon"`
+	} `json:"weather" xml:"weather"`
+	Base string `json:"base" xml:"base"`
 	Main struct {
-		Temp     float64 `json:"temp"`
-		Pressure int     `json:"pressure"`
-		Humidity int     `json:"humidity"`
-		TempMin  float64 `json:"temp_min"`
-		TempMax  float64 `json:"temp_max"`
-	} `json:"main"`
-	Visibility int `json:"visibility"`
+		Temp     float64 `json:"temp" xml:"temp"`
+		Pressure int     `json:"pressure" xml:"pressure"`
+		Humidity int     `json:"humidity" xml:"humidity"`
+		TempMin  float64 `json:"temp_min" xml:"temp_min"`
+		TempMax  float64 `json:"temp_max" xml:"temp_max"`
+	} `json:"main" xml:"main"`
+	Visibility int `json:"visibility" xml:"visibility"`
 	Wind       struct {
-		Speed float64 `json:"speed"`
-		Deg   int     `json:"deg"`
-	} `json:"wind"`
+		Speed float64 `json:"speed" xml:"speed"`
+		Deg   int     `json:"deg" xml:"deg"`
+	} `json:"wind" xml:"wind"`
 	Clouds struct {
-		All int `json:"all"`
-	} `json:"clouds"`
-	Dt  int `json:"dt"`
+		All int `json:"all" xml:"all"`
+	} `json:"clouds" xml:"clouds"`
+	Dt  int `json:"dt" xml:"dt"`
 	Sys struct {
-		Type    int     `json:"type"`
-		ID      int     `json:"id"`
-		Message float64 `json:"message"`
-		Country string  `json:"country"`
-		Sunrise int     `json:"sunrise"`
-		Sunset  int     `json:"sunset"`
-	} `json:"sys"`
-	ID   int    `json:"id"`
-	Name string `json:"name"`
-	Cod  int    `json:"cod"`
+		Type    int     `json:"type" xml:"type"`
+		ID      int     `json:"id" xml:"id"`
+		Message float64 `json:"message" xml:"message"`
+		Country string  `json:"country" xml:"country"`
+		Sunrise int     `json:"sunrise" xml:"sunrise"`
+		Sunset  int     `json:"sunset" xml:"sunset"`
+	} `json:"sys" xml:"sys"`
+	ID   int    `json:"id" xml:"id"`
+	Name string `json:"name" xml:"name"`
+	Cod  int    `json:"cod" xml:"cod"`
 }
 
 // Forecasts define weather conditions for multiple cities
 type Forecasts struct {
 	Cities map[string]*Forecast `json:"cities"`
+	// Meta tells how fresh forecasts are, keyed like Cities
+	Meta map[string]*Freshness `json:"meta,omitempty"`
+	// ExpiresAt is when the first of forecasts expires in storage, zero if unknown
+	ExpiresAt time.Time `json:"-"`
+}
+
+// Freshness tells where forecast came from and how old it is
+type Freshness struct {
+	// FetchedAt is when forecast was fetched from external provider
+	FetchedAt  time.Time `json:"fetched_at" xml:"fetched_at,attr"`
+	AgeSeconds int64     `json:"age_seconds" xml:"age_seconds,attr"`
+	// Source is name of external provider on cache miss, or of storage on hit
+	Source   string `json:"source" xml:"source,attr"`
+	CacheHit bool   `json:"cache_hit" xml:"cache_hit,attr"`
+	// Stale means forecast is older than requested, but couldn't be refreshed
+	Stale bool `json:"stale" xml:"stale,attr"`
+}
+
+// LastModified returns time of the most recent forecast, or zero time if no
+// forecast tells when it was measured
+func (f *Forecasts) LastModified() time.Time {
+	var dt int
+	for _, forecast := range f.Cities {
+		if forecast != nil && forecast.Dt > dt {
+			dt = forecast.Dt
+		}
+	}
+	if dt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(dt), 0)
 }
 
 // NewForecasts return initialized Forecasts
 func NewForecasts() *Forecasts {
 	return &Forecasts{
 		Cities: map[string]*Forecast{},
+		Meta:   map[string]*Freshness{},
+	}
+}
+
+// History is a time series of forecasts for one city, ordered by Dt
+type History struct {
+	City      string      `json:"city"`
+	Forecasts []*Forecast `json:"forecasts"`
+}
+
+// Query describes which forecast is requested. Location is given by exactly
+// one of City, ID or Coord.
+type Query struct {
+	City  string
+	ID    int
+	Coord *Coord
+	// Units is one of standard, metric or imperial, upstream default if empty
+	Units string
+	// Lang is a language of descriptions, upstream default if empty
+	Lang string
+	// MaxAge makes stored forecasts older than it fetched again, zero accepts any age
+	MaxAge time.Duration
+	// Refresh skips storage and fetches forecast from external provider
+	Refresh bool
+}
+
+// Coord is a geographic location
+type Coord struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+// Location identifies location of query in Forecasts
+func (q Query) Location() string {
+	switch {
+	case q.City != "":
+		return q.City
+	case q.ID != 0:
+		return "id:" + strconv.Itoa(q.ID)
+	case q.Coord != nil:
+		return strconv.FormatFloat(q.Coord.Lat, 'f', -1, 64) + "," + strconv.FormatFloat(q.Coord.Lon, 'f', -1, 64)
 	}
+	return ""
+}
+
+// Key identifies forecast for query in storage. Queries without options
+// are identified just by location.
+func (q Query) Key() string {
+	v := url.Values{}
+	if q.Lang != "" {
+		v.Set("lang", q.Lang)
+	}
+	if q.Units != "" {
+		v.Set("units", q.Units)
+	}
+	if len(v) == 0 {
+		return q.Location()
+	}
+	return q.Location() + "?" + v.Encode()
 }
 
 // Errors visible for client
@@ -66,8 +158,32 @@ var ErrForecastNotFound = errors.New("forecast not found")
 // ErrMisconfigured means error in service config
 var ErrMisconfigured = errors.New("misconfigured service")
 
+// ErrUnsupportedQuery means external provider can look up forecasts only by
+// city name, without converting units or translating descriptions
+var ErrUnsupportedQuery = errors.New("query not supported")
+
 // ErrTooManyRequests means we've exceeded limit in external weather service
 var ErrTooManyRequests = errors.New("too many requests")
 
+// ErrUnavailable means external weather service couldn't be reached or failed
+var ErrUnavailable = errors.New("external service unavailable")
+
+// ErrTimeout means external weather service didn't respond in time
+var ErrTimeout = errors.New("external service timeout")
+
 // ErrInternal means any other error
 var ErrInternal = errors.New("internal error")
+
+// RetryAfterError wraps error which is expected to go away after some time
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
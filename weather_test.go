@@ -0,0 +1,37 @@
+package weather
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestForecasts_LastModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		cities map[string]*Forecast
+		want   time.Time
+	}{
+		{
+			name:   "No forecasts",
+			cities: map[string]*Forecast{},
+		},
+		{
+			name:   "Forecasts without measurement time",
+			cities: map[string]*Forecast{"london": {}, "warsaw": nil},
+		},
+		{
+			name:   "The most recent forecast",
+			cities: map[string]*Forecast{"london": {Dt: 1588016537}, "warsaw": {Dt: 1588016806}, "paris": {}},
+			want:   time.Unix(1588016806, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Forecasts{Cities: tt.cities}
+
+			assert.Equal(t, tt.want, f.LastModified())
+		})
+	}
+}
@@ -1,21 +1,59 @@
 package cache
 
 import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/papisz/weather"
-	gocache "github.com/patrickmn/go-cache"
+	"github.com/papisz/weather/weathersrc"
 )
 
+// Source is the name under which cache reports its entries
+const Source = "memory"
+
+// CacheWeatherSrc is an in-memory storage. It can be bounded by number of
+// entries and by their approximate size, least recently used entries are
+// evicted first. Cities are case-insensitive, like in other storages.
 type CacheWeatherSrc struct {
-	cache *gocache.Cache
-	ttl   time.Duration
+	mu         sync.Mutex
+	items      map[string]*list.Element
+	lru        *list.List // most recently used at front
+	ttl        time.Duration
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	evictions  uint64
+	lastSweep  time.Time
+	now        func() time.Time
+}
+
+// Stats describes current cache usage
+type Stats struct {
+	Entries   int    `json:"entries"`
+	Bytes     int64  `json:"bytes"`
+	Evictions uint64 `json:"evictions"`
+}
+
+type entry struct {
+	city      string
+	forecast  *weather.Forecast
+	storedAt  time.Time
+	expiresAt time.Time
+	size      int64
 }
 
 type Option func(provider *CacheWeatherSrc)
 
 func NewWeatherSrc(opts ...Option) *CacheWeatherSrc {
-	provider := &CacheWeatherSrc{}
+	provider := &CacheWeatherSrc{
+		items: map[string]*list.Element{},
+		lru:   list.New(),
+		now:   time.Now,
+	}
 
 	for _, opt := range opts {
 		opt(provider)
@@ -24,22 +62,258 @@ func NewWeatherSrc(opts ...Option) *CacheWeatherSrc {
 	return provider
 }
 
+// WithTTL sets how long forecasts are kept. Zero means they never expire.
 func WithTTL(ttl time.Duration) Option {
 	return func(provider *CacheWeatherSrc) {
 		provider.ttl = ttl
-		provider.cache = gocache.New(provider.ttl, provider.ttl)
+	}
+}
+
+// WithMaxEntries limits number of cached forecasts. Zero means no limit.
+func WithMaxEntries(maxEntries int) Option {
+	return func(provider *CacheWeatherSrc) {
+		provider.maxEntries = maxEntries
+	}
+}
+
+// WithMaxBytes limits approximate memory used by cached forecasts. Zero means no limit.
+func WithMaxBytes(maxBytes int64) Option {
+	return func(provider *CacheWeatherSrc) {
+		provider.maxBytes = maxBytes
+	}
+}
+
+// WithClock sets time source used for expiry, e.g. a fake clock in tests
+func WithClock(now func() time.Time) Option {
+	return func(provider *CacheWeatherSrc) {
+		provider.now = now
+	}
+}
+
+// SetTTL changes how long forecasts saved from now on are kept, already
+// cached ones keep their expiry time
+func (p *CacheWeatherSrc) SetTTL(ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ttl = ttl
+}
+
+// SetLimits changes cache bounds, least recently used entries are evicted
+// right away if the cache no longer fits
+func (p *CacheWeatherSrc) SetLimits(maxEntries int, maxBytes int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.maxEntries = maxEntries
+	p.maxBytes = maxBytes
+	for p.overLimit() {
+		p.remove(p.lru.Back())
+		p.evictions++
 	}
 }
 
 func (p *CacheWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
-	if x, found := p.cache.Get(city); found {
-		return x.(*weather.Forecast), nil
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if el, found := p.items[key(city)]; found {
+		e := el.Value.(*entry)
+		if !p.expired(e) {
+			p.lru.MoveToFront(el)
+			return e.forecast, nil
+		}
+		p.remove(el)
 	}
 
 	return nil, weather.ErrForecastNotFound
 }
 
+// SaveForecast rejects forecasts which can't be encoded, like disk storage,
+// so they aren't served until they expire
 func (p *CacheWeatherSrc) SaveForecast(city string, forecast *weather.Forecast) error {
-	p.cache.Set(city, forecast, gocache.DefaultExpiration)
+	return p.SaveForecastUntil(city, forecast, time.Time{})
+}
+
+// SaveForecastUntil saves forecast which expires at expiresAt, unless TTL
+// runs out earlier
+func (p *CacheWeatherSrc) SaveForecastUntil(city string, forecast *weather.Forecast, expiresAt time.Time) error {
+	size, err := sizeOf(city, forecast)
+	if err != nil {
+		return fmt.Errorf("unable to marshal forecast: %v", err)
+	}
+	e := &entry{
+		city:     city,
+		forecast: forecast,
+		size:     size,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	e.storedAt = now
+	if p.ttl > 0 {
+		e.expiresAt = now.Add(p.ttl)
+		if now.Sub(p.lastSweep) >= p.ttl {
+			p.removeExpired()
+			p.lastSweep = now
+		}
+	}
+	if !expiresAt.IsZero() && (e.expiresAt.IsZero() || expiresAt.Before(e.expiresAt)) {
+		e.expiresAt = expiresAt
+	}
+
+	if el, found := p.items[key(city)]; found {
+		p.remove(el)
+	}
+	p.items[key(city)] = p.lru.PushFront(e)
+	p.bytes += e.size
+
+	for p.overLimit() {
+		p.remove(p.lru.Back())
+		p.evictions++
+	}
 	return nil
 }
+
+func (p *CacheWeatherSrc) DeleteForecast(city string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	el, found := p.items[key(city)]
+	if !found {
+		return weather.ErrForecastNotFound
+	}
+
+	expired := p.expired(el.Value.(*entry))
+	p.remove(el)
+	if expired {
+		return weather.ErrForecastNotFound
+	}
+	return nil
+}
+
+func (p *CacheWeatherSrc) GetEntry(city string) (weathersrc.Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	el, found := p.items[key(city)]
+	if !found || p.expired(el.Value.(*entry)) {
+		return weathersrc.Entry{}, weather.ErrForecastNotFound
+	}
+	return el.Value.(*entry).toEntry(), nil
+}
+
+// ListForecasts returns entries starting from the most recently used
+func (p *CacheWeatherSrc) ListForecasts() ([]weathersrc.Entry, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entries := make([]weathersrc.Entry, 0, p.lru.Len())
+	for el := p.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		if p.expired(e) {
+			continue
+		}
+		entries = append(entries, e.toEntry())
+	}
+	return entries, nil
+}
+
+func (p *CacheWeatherSrc) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.items = map[string]*list.Element{}
+	p.lru.Init()
+	p.bytes = 0
+	return nil
+}
+
+// Stats returns current cache usage
+func (p *CacheWeatherSrc) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return Stats{
+		Entries:   p.lru.Len(),
+		Bytes:     p.bytes,
+		Evictions: p.evictions,
+	}
+}
+
+func (p *CacheWeatherSrc) overLimit() bool {
+	if p.lru.Len() == 0 {
+		return false
+	}
+	return (p.maxEntries > 0 && p.lru.Len() > p.maxEntries) ||
+		(p.maxBytes > 0 && p.bytes > p.maxBytes)
+}
+
+func (p *CacheWeatherSrc) expired(e *entry) bool {
+	return !e.expiresAt.IsZero() && !p.now().Before(e.expiresAt)
+}
+
+func (p *CacheWeatherSrc) removeExpired() {
+	for el := p.lru.Front(); el != nil; {
+		next := el.Next()
+		if p.expired(el.Value.(*entry)) {
+			p.remove(el)
+		}
+		el = next
+	}
+}
+
+func (p *CacheWeatherSrc) remove(el *list.Element) {
+	e := p.lru.Remove(el).(*entry)
+	delete(p.items, key(e.city))
+	p.bytes -= e.size
+}
+
+// key makes lookups case-insensitive, entries keep the spelling of the last save
+func key(city string) string {
+	return strings.ToLower(city)
+}
+
+func (e *entry) toEntry() weathersrc.Entry {
+	return weathersrc.Entry{
+		City:      e.city,
+		Source:    Source,
+		StoredAt:  e.storedAt,
+		ExpiresAt: e.expiresAt,
+	}
+}
+
+// sizeOf approximates memory used by entry with its JSON size
+func sizeOf(city string, forecast *weather.Forecast) (int64, error) {
+	jsonBytes, err := json.Marshal(forecast)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(city) + len(jsonBytes)), nil
+}
+
+func init() {
+	weathersrc.RegisterStorage(Source, newFromSettings, weathersrc.ConfigKeys{
+		"ttl":         "cache_ttl",
+		"max_entries": "cache_max_entries",
+		"max_bytes":   "cache_max_bytes",
+	})
+}
+
+// newFromSettings builds cache from ttl, max_entries and max_bytes
+func newFromSettings(s weathersrc.Settings) (weathersrc.WriteableForecastProvider, error) {
+	ttl, err := s.Duration("ttl")
+	if err != nil {
+		return nil, err
+	}
+	maxEntries, err := s.Int("max_entries")
+	if err != nil {
+		return nil, err
+	}
+	maxBytes, err := s.Int("max_bytes")
+	if err != nil {
+		return nil, err
+	}
+	return NewWeatherSrc(WithTTL(ttl), WithMaxEntries(int(maxEntries)), WithMaxBytes(maxBytes)), nil
+}
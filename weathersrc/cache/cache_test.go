@@ -1,11 +1,14 @@
 package cache
 
 import (
+	"math"
 	"testing"
 	"time"
 
 	"github.com/papisz/weather"
 	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/providertest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,6 +36,18 @@ func TestCacheWeatherSrc_GetSaveForecast(t *testing.T) {
 				forecast: testutils.ForecastFromJSON("warsaw.json"),
 			},
 		},
+		{
+			name: "Forecast which can't be encoded",
+			args: args{
+				city: "London",
+				forecast: func() *weather.Forecast {
+					f := testutils.ForecastFromJSON("london.json")
+					f.Main.Temp = math.NaN()
+					return f
+				}(),
+			},
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -47,9 +62,200 @@ func TestCacheWeatherSrc_GetSaveForecast(t *testing.T) {
 			}
 
 			forecast, err = p.GetForecast(tt.args.city)
+			if tt.wantErr {
+				assert.Equal(t, weather.ErrForecastNotFound, err)
+				return
+			}
 
 			assert.Nil(t, err)
 			assert.Equal(t, tt.args.forecast, forecast)
 		})
 	}
 }
+
+func TestCacheWeatherSrc_Eviction(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	size, _ := sizeOf("a", london)
+
+	tests := []struct {
+		name          string
+		opts          []Option
+		expectedStats Stats
+		expectedKeys  []string
+	}{
+		{
+			name:          "No limits",
+			opts:          []Option{WithTTL(time.Minute)},
+			expectedStats: Stats{Entries: 4, Bytes: 4 * size},
+			expectedKeys:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:          "Max entries",
+			opts:          []Option{WithMaxEntries(3)},
+			expectedStats: Stats{Entries: 3, Bytes: 3 * size, Evictions: 1},
+			expectedKeys:  []string{"a", "c", "d"},
+		},
+		{
+			name:          "Max bytes",
+			opts:          []Option{WithMaxBytes(2*size + 1)},
+			expectedStats: Stats{Entries: 2, Bytes: 2 * size, Evictions: 2},
+			expectedKeys:  []string{"c", "d"},
+		},
+		{
+			name:          "Entry bigger than max bytes",
+			opts:          []Option{WithMaxBytes(size - 1)},
+			expectedStats: Stats{Entries: 0, Bytes: 0, Evictions: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewWeatherSrc(tt.opts...)
+
+			p.SaveForecast("a", london)
+			p.SaveForecast("b", london)
+			p.SaveForecast("c", london)
+			// "a" becomes most recently used, so "b" is evicted first
+			p.GetForecast("a")
+			p.SaveForecast("d", london)
+
+			assert.Equal(t, tt.expectedStats, p.Stats())
+
+			var keys []string
+			for _, key := range []string{"a", "b", "c", "d"} {
+				if _, err := p.GetForecast(key); err == nil {
+					keys = append(keys, key)
+				}
+			}
+			assert.Equal(t, tt.expectedKeys, keys)
+		})
+	}
+}
+
+func TestCacheWeatherSrc_Expiry(t *testing.T) {
+	now := time.Now()
+	p := NewWeatherSrc(WithTTL(time.Minute))
+	p.now = func() time.Time { return now }
+
+	p.SaveForecast("London", testutils.ForecastFromJSON("london.json"))
+	now = now.Add(30 * time.Second)
+	p.SaveForecast("Warsaw", testutils.ForecastFromJSON("warsaw.json"))
+
+	now = now.Add(45 * time.Second)
+	_, err := p.GetForecast("London")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+	_, err = p.GetForecast("Warsaw")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, p.Stats().Entries)
+
+	// expired entries are swept on save, even if nobody asks for them
+	now = now.Add(time.Minute)
+	p.SaveForecast("Berlin", &weather.Forecast{})
+	assert.Equal(t, 1, p.Stats().Entries)
+}
+
+func TestCacheWeatherSrc_SaveForecastUntil(t *testing.T) {
+	now := time.Now()
+	london := testutils.ForecastFromJSON("london.json")
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		expiresAt time.Time
+		want      time.Time
+	}{
+		{name: "Earlier than TTL", ttl: time.Hour, expiresAt: now.Add(time.Minute), want: now.Add(time.Minute)},
+		{name: "Later than TTL", ttl: time.Hour, expiresAt: now.Add(2 * time.Hour), want: now.Add(time.Hour)},
+		{name: "Without TTL", expiresAt: now.Add(time.Minute), want: now.Add(time.Minute)},
+		{name: "Zero keeps TTL", ttl: time.Hour, want: now.Add(time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewWeatherSrc(WithTTL(tt.ttl), WithClock(func() time.Time { return now }))
+
+			assert.Nil(t, p.SaveForecastUntil("London", london, tt.expiresAt))
+			entry, err := p.GetEntry("London")
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, entry.ExpiresAt)
+		})
+	}
+}
+
+func TestCacheWeatherSrc_DeleteListFlush(t *testing.T) {
+	now := time.Now()
+	p := NewWeatherSrc(WithTTL(time.Minute))
+	p.now = func() time.Time { return now }
+
+	p.SaveForecast("London", testutils.ForecastFromJSON("london.json"))
+	p.SaveForecast("Warsaw", testutils.ForecastFromJSON("warsaw.json"))
+
+	entries, err := p.ListForecasts()
+	assert.Nil(t, err)
+	assert.Equal(t, []weathersrc.Entry{
+		{City: "Warsaw", Source: Source, StoredAt: now, ExpiresAt: now.Add(time.Minute)},
+		{City: "London", Source: Source, StoredAt: now, ExpiresAt: now.Add(time.Minute)},
+	}, entries)
+
+	assert.Nil(t, p.DeleteForecast("London"))
+	assert.Equal(t, weather.ErrForecastNotFound, p.DeleteForecast("London"))
+	entries, _ = p.ListForecasts()
+	assert.Len(t, entries, 1)
+
+	assert.Nil(t, p.Flush())
+	entries, _ = p.ListForecasts()
+	assert.Empty(t, entries)
+	assert.Equal(t, Stats{}, p.Stats())
+}
+
+func TestCacheWeatherSrc_CaseInsensitive(t *testing.T) {
+	now := time.Now()
+	p := NewWeatherSrc(WithClock(func() time.Time { return now }))
+	london := testutils.ForecastFromJSON("london.json")
+
+	assert.Nil(t, p.SaveForecast("london", london))
+	assert.Nil(t, p.SaveForecast("London", london))
+	assert.Equal(t, 1, p.Stats().Entries)
+
+	forecast, err := p.GetForecast("LONDON")
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecast)
+	entry, err := p.GetEntry("lOnDoN")
+	assert.Nil(t, err)
+	assert.Equal(t, weathersrc.Entry{City: "London", Source: Source, StoredAt: now}, entry)
+
+	assert.Nil(t, p.DeleteForecast("LONDON"))
+	_, err = p.GetForecast("London")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+	assert.Equal(t, Stats{}, p.Stats())
+}
+
+func TestCacheWeatherSrc_SetTTLAndLimits(t *testing.T) {
+	now := time.Now()
+	p := NewWeatherSrc(WithTTL(time.Minute))
+	p.now = func() time.Time { return now }
+
+	p.SaveForecast("London", testutils.ForecastFromJSON("london.json"))
+	p.SetTTL(time.Hour)
+	p.SaveForecast("Warsaw", testutils.ForecastFromJSON("warsaw.json"))
+
+	// new TTL applies only to forecasts saved after the change
+	now = now.Add(2 * time.Minute)
+	_, err := p.GetForecast("London")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+	_, err = p.GetForecast("Warsaw")
+	assert.Nil(t, err)
+
+	p.SaveForecast("Berlin", &weather.Forecast{})
+	p.SetLimits(1, 0)
+	assert.Equal(t, 1, p.Stats().Entries)
+	_, err = p.GetForecast("Berlin")
+	assert.Nil(t, err)
+}
+
+func TestCacheWeatherSrc_Contract(t *testing.T) {
+	providertest.TestWriteableForecastProvider(t, func(t *testing.T, clock *providertest.Clock, ttl time.Duration) weathersrc.WriteableForecastProvider {
+		p := NewWeatherSrc(WithTTL(ttl))
+		p.now = clock.Now
+		return p
+	}, testutils.ForecastFromJSON("london.json"))
+}
@@ -0,0 +1,70 @@
+package chain
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+)
+
+// ChainWeatherSrc asks external providers in order and returns the first
+// forecast found, so e.g. local files can stand in when the API is down.
+type ChainWeatherSrc struct {
+	providers []weathersrc.ForecastProvider
+}
+
+type Option func(provider *ChainWeatherSrc)
+
+func NewWeatherSrc(opts ...Option) *ChainWeatherSrc {
+	provider := &ChainWeatherSrc{}
+
+	for _, opt := range opts {
+		opt(provider)
+	}
+
+	return provider
+}
+
+// WithProvider appends a provider asked after already added ones
+func WithProvider(provider weathersrc.ForecastProvider) Option {
+	return func(chain *ChainWeatherSrc) {
+		chain.providers = append(chain.providers, provider)
+	}
+}
+
+// Name is reported only where the provider which answered isn't known,
+// fetched forecasts report their provider, see QuerySource
+func (p *ChainWeatherSrc) Name() string {
+	return "chain"
+}
+
+func (p *ChainWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	return p.QueryForecast(weather.Query{City: city})
+}
+
+func (p *ChainWeatherSrc) QueryForecast(q weather.Query) (*weather.Forecast, error) {
+	forecast, _, err := p.QuerySource(q)
+	return forecast, err
+}
+
+// QuerySource returns the first forecast found with name of the provider
+// which had it. If no provider has it, the first error other than
+// weather.ErrForecastNotFound is returned.
+func (p *ChainWeatherSrc) QuerySource(q weather.Query) (*weather.Forecast, string, error) {
+	var firstErr error
+	for i, provider := range p.providers {
+		forecast, source, err := weathersrc.Fetch(provider, q)
+		if err == nil {
+			return forecast, source, nil
+		}
+		if firstErr == nil && !errors.Is(err, weather.ErrForecastNotFound) {
+			firstErr = fmt.Errorf("error fetching forecast from provider %d for %s: %w", i, q.Location(), err)
+		}
+	}
+
+	if firstErr != nil {
+		return nil, "", firstErr
+	}
+	return nil, "", weather.ErrForecastNotFound
+}
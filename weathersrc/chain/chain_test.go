@@ -0,0 +1,142 @@
+package chain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func returning(forecast *weather.Forecast, err error) weathersrc.ForecastProvider {
+	return providertest.Func(func(string) (*weather.Forecast, error) {
+		return forecast, err
+	})
+}
+
+func TestChainWeatherSrc_GetForecast(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	errBroken := errors.New("broken")
+
+	tests := []struct {
+		name        string
+		providers   []weathersrc.ForecastProvider
+		want        *weather.Forecast
+		expectedErr error
+	}{
+		{
+			name:      "First provider has it",
+			providers: []weathersrc.ForecastProvider{returning(london, nil), returning(nil, errBroken)},
+			want:      london,
+		},
+		{
+			name:      "Fallback after error",
+			providers: []weathersrc.ForecastProvider{returning(nil, weather.ErrUnavailable), returning(london, nil)},
+			want:      london,
+		},
+		{
+			name:        "Not found anywhere",
+			providers:   []weathersrc.ForecastProvider{returning(nil, weather.ErrForecastNotFound), returning(nil, weather.ErrForecastNotFound)},
+			expectedErr: weather.ErrForecastNotFound,
+		},
+		{
+			name:        "First real error wins over not found",
+			providers:   []weathersrc.ForecastProvider{returning(nil, weather.ErrForecastNotFound), returning(nil, weather.ErrTimeout), returning(nil, errBroken)},
+			expectedErr: weather.ErrTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewWeatherSrc()
+			for _, provider := range tt.providers {
+				WithProvider(provider)(p)
+			}
+
+			got, err := p.GetForecast("London")
+			assert.True(t, errors.Is(err, tt.expectedErr))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestChainWeatherSrc_QuerySource(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+
+	tests := []struct {
+		name       string
+		providers  []weathersrc.ForecastProvider
+		wantSource string
+	}{
+		{
+			name:       "Named provider",
+			providers:  []weathersrc.ForecastProvider{returning(nil, weather.ErrUnavailable), &namedProvider{"file", returning(london, nil)}},
+			wantSource: "file",
+		},
+		{
+			name:       "Unnamed provider",
+			providers:  []weathersrc.ForecastProvider{&namedProvider{"file", returning(nil, weather.ErrForecastNotFound)}, returning(london, nil)},
+			wantSource: "external",
+		},
+		{
+			name: "Nested chain",
+			providers: []weathersrc.ForecastProvider{NewWeatherSrc(
+				WithProvider(&namedProvider{"openweather", returning(nil, weather.ErrTimeout)}),
+				WithProvider(&namedProvider{"file", returning(london, nil)}),
+			)},
+			wantSource: "file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewWeatherSrc()
+			for _, provider := range tt.providers {
+				WithProvider(provider)(p)
+			}
+
+			got, source, err := p.QuerySource(weather.Query{City: "London"})
+			assert.Nil(t, err)
+			assert.Equal(t, london, got)
+			assert.Equal(t, tt.wantSource, source)
+		})
+	}
+
+	_, source, err := NewWeatherSrc(WithProvider(returning(nil, weather.ErrTimeout))).QuerySource(weather.Query{City: "London"})
+	assert.True(t, errors.Is(err, weather.ErrTimeout))
+	assert.Empty(t, source)
+}
+
+// namedProvider gives a name to provider
+type namedProvider struct {
+	name string
+	weathersrc.ForecastProvider
+}
+
+func (p *namedProvider) Name() string {
+	return p.name
+}
+
+func TestChainWeatherSrc_Contract(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	fallback := providertest.Func(func(city string) (*weather.Forecast, error) {
+		if city == "London" {
+			return london, nil
+		}
+		return nil, weather.ErrForecastNotFound
+	})
+
+	primary := providertest.Func(func(city string) (*weather.Forecast, error) {
+		if city == "London" {
+			return nil, weather.ErrUnavailable
+		}
+		return nil, weather.ErrForecastNotFound
+	})
+	providertest.TestForecastProvider(t, NewWeatherSrc(WithProvider(primary), WithProvider(fallback)), "London", "Atlantis")
+
+	providertest.TestErrors(t, "Atlantis", map[error]weathersrc.ForecastProvider{
+		weather.ErrForecastNotFound: NewWeatherSrc(WithProvider(fallback), WithProvider(fallback)),
+		weather.ErrTimeout:          NewWeatherSrc(WithProvider(fallback), WithProvider(returning(nil, weather.ErrTimeout))),
+	})
+}
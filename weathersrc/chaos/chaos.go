@@ -0,0 +1,234 @@
+package chaos
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+)
+
+// Fault is what happens to a single call. Zero Fault passes the call through.
+type Fault struct {
+	// Delay is waited before the call
+	Delay time.Duration
+	// Err is returned instead of calling the wrapped provider
+	Err error
+	// Corrupt fails reads with the error decoding a broken response gives,
+	// after calling the wrapped provider
+	Corrupt bool
+}
+
+// Errors commonly injected, matching what external providers return
+var (
+	ErrTimeout         = weather.ErrTimeout
+	ErrMisconfigured   = weather.ErrMisconfigured
+	ErrTooManyRequests = &weather.RetryAfterError{Err: weather.ErrTooManyRequests, RetryAfter: time.Second}
+)
+
+type rule struct {
+	probability float64
+	fault       Fault
+	// errs are picked from at random when the rule fires
+	errs []error
+}
+
+// ChaosWeatherSrc wraps a provider and injects delays, errors and corrupted
+// responses. Scripted faults are used first, one per call, then every rule
+// fires with its probability.
+type ChaosWeatherSrc struct {
+	provider weathersrc.ForecastProvider
+
+	// mu guards rnd and script
+	mu     sync.Mutex
+	rnd    *rand.Rand
+	script []Fault
+	rules  []rule
+	sleep  func(time.Duration)
+}
+
+type Option func(provider *ChaosWeatherSrc)
+
+func NewWeatherSrc(provider weathersrc.ForecastProvider, opts ...Option) *ChaosWeatherSrc {
+	chaos := &ChaosWeatherSrc{
+		provider: provider,
+		rnd:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleep:    time.Sleep,
+	}
+
+	for _, opt := range opts {
+		opt(chaos)
+	}
+
+	return chaos
+}
+
+// WithSeed makes random faults repeatable
+func WithSeed(seed int64) Option {
+	return func(provider *ChaosWeatherSrc) {
+		provider.rnd = rand.New(rand.NewSource(seed))
+	}
+}
+
+// WithDelay delays given share of calls, from 0 to 1
+func WithDelay(probability float64, delay time.Duration) Option {
+	return WithFault(probability, Fault{Delay: delay})
+}
+
+// WithError fails given share of calls, from 0 to 1
+func WithError(probability float64, err error) Option {
+	return WithFault(probability, Fault{Err: err})
+}
+
+// WithErrors fails given share of calls, from 0 to 1, with one of errs
+// picked at random
+func WithErrors(probability float64, errs ...error) Option {
+	return func(provider *ChaosWeatherSrc) {
+		provider.rules = append(provider.rules, rule{probability: probability, errs: errs})
+	}
+}
+
+// WithCorruption corrupts forecasts returned by given share of reads, from 0 to 1
+func WithCorruption(probability float64) Option {
+	return WithFault(probability, Fault{Corrupt: true})
+}
+
+// WithFault injects fault into given share of calls, from 0 to 1. When several
+// faults fire, delays add up and the first error wins.
+func WithFault(probability float64, fault Fault) Option {
+	return func(provider *ChaosWeatherSrc) {
+		provider.rules = append(provider.rules, rule{probability: probability, fault: fault})
+	}
+}
+
+// WithScript injects given faults into the following calls, in order
+func WithScript(faults ...Fault) Option {
+	return func(provider *ChaosWeatherSrc) {
+		provider.script = append(provider.script, faults...)
+	}
+}
+
+// Name returns name of wrapped provider
+func (p *ChaosWeatherSrc) Name() string {
+	return weathersrc.SourceName(p.provider)
+}
+
+func (p *ChaosWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	return p.QueryForecast(weather.Query{City: city})
+}
+
+func (p *ChaosWeatherSrc) QueryForecast(q weather.Query) (*weather.Forecast, error) {
+	forecast, _, err := p.QuerySource(q)
+	return forecast, err
+}
+
+// QuerySource injects fault and reports the provider of wrapped one
+func (p *ChaosWeatherSrc) QuerySource(q weather.Query) (*weather.Forecast, string, error) {
+	fault := p.next()
+	if fault.Err != nil {
+		return nil, "", fault.Err
+	}
+
+	forecast, source, err := weathersrc.Fetch(p.provider, q)
+	if err != nil || !fault.Corrupt {
+		return forecast, source, err
+	}
+	return nil, "", corrupt(forecast)
+}
+
+// next picks fault for a call and waits for its delay
+func (p *ChaosWeatherSrc) next() Fault {
+	p.mu.Lock()
+	var fault Fault
+	if len(p.script) > 0 {
+		fault, p.script = p.script[0], p.script[1:]
+	} else {
+		for _, r := range p.rules {
+			if p.rnd.Float64() >= r.probability {
+				continue
+			}
+			fault.Delay += r.fault.Delay
+			fault.Corrupt = fault.Corrupt || r.fault.Corrupt
+			err := r.fault.Err
+			if len(r.errs) > 0 {
+				err = r.errs[p.rnd.Intn(len(r.errs))]
+			}
+			if fault.Err == nil {
+				fault.Err = err
+			}
+		}
+	}
+	p.mu.Unlock()
+
+	if fault.Delay > 0 {
+		p.sleep(fault.Delay)
+	}
+	return fault
+}
+
+// corrupt returns the error decoding truncated forecast gives, like the one
+// providers fail with when upstream sends broken data. Returning a broken
+// forecast instead would get it stored and served until it expires.
+func corrupt(forecast *weather.Forecast) error {
+	jsonBytes, err := json.Marshal(forecast)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonBytes[:len(jsonBytes)/2], &weather.Forecast{})
+}
+
+// WriteableChaosWeatherSrc wraps a storage, faults are injected into writes
+// as well, corruption applies only to reads
+type WriteableChaosWeatherSrc struct {
+	*ChaosWeatherSrc
+	storage weathersrc.WriteableForecastProvider
+}
+
+func NewWriteableWeatherSrc(storage weathersrc.WriteableForecastProvider, opts ...Option) *WriteableChaosWeatherSrc {
+	return &WriteableChaosWeatherSrc{
+		ChaosWeatherSrc: NewWeatherSrc(storage, opts...),
+		storage:         storage,
+	}
+}
+
+func (p *WriteableChaosWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	fault := p.next()
+	if fault.Err != nil {
+		return nil, fault.Err
+	}
+	forecast, err := p.storage.GetForecast(city)
+	if err != nil || !fault.Corrupt {
+		return forecast, err
+	}
+	return nil, corrupt(forecast)
+}
+
+func (p *WriteableChaosWeatherSrc) SaveForecast(city string, forecast *weather.Forecast) error {
+	if fault := p.next(); fault.Err != nil {
+		return fault.Err
+	}
+	return p.storage.SaveForecast(city, forecast)
+}
+
+func (p *WriteableChaosWeatherSrc) DeleteForecast(city string) error {
+	if fault := p.next(); fault.Err != nil {
+		return fault.Err
+	}
+	return p.storage.DeleteForecast(city)
+}
+
+func (p *WriteableChaosWeatherSrc) ListForecasts() ([]weathersrc.Entry, error) {
+	if fault := p.next(); fault.Err != nil {
+		return nil, fault.Err
+	}
+	return p.storage.ListForecasts()
+}
+
+func (p *WriteableChaosWeatherSrc) Flush() error {
+	if fault := p.next(); fault.Err != nil {
+		return fault.Err
+	}
+	return p.storage.Flush()
+}
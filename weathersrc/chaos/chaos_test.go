@@ -0,0 +1,191 @@
+package chaos
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/cache"
+	"github.com/papisz/weather/weathersrc/file"
+	"github.com/papisz/weather/weathersrc/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChaosWeatherSrc_Script(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	calls := 0
+	var slept []time.Duration
+	p := NewWeatherSrc(providertest.Func(func(city string) (*weather.Forecast, error) {
+		calls++
+		return london, nil
+	}), WithScript(
+		Fault{Err: ErrTooManyRequests},
+		Fault{Delay: time.Second, Err: ErrTimeout},
+		Fault{Corrupt: true},
+		Fault{},
+	), WithError(1, ErrMisconfigured))
+	p.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+	_, err := p.GetForecast("London")
+	assert.True(t, errors.Is(err, weather.ErrTooManyRequests))
+
+	_, err = p.GetForecast("London")
+	assert.True(t, errors.Is(err, weather.ErrTimeout))
+	assert.Equal(t, []time.Duration{time.Second}, slept)
+
+	forecast, err := p.GetForecast("London")
+	assert.Nil(t, forecast)
+	assert.True(t, isCorrupted(err), err)
+	assert.Equal(t, "London", london.Name, "original forecast is left intact")
+
+	forecast, err = p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecast)
+
+	// rules apply once the script is over
+	_, err = p.GetForecast("London")
+	assert.Equal(t, weather.ErrMisconfigured, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestChaosWeatherSrc_QuerySource(t *testing.T) {
+	p := NewWeatherSrc(file.NewWeatherSrc(file.WithDirPath("../../testdata/source")), WithScript(Fault{Err: ErrTimeout}))
+
+	_, source, err := p.QuerySource(weather.Query{City: "London"})
+	assert.True(t, errors.Is(err, weather.ErrTimeout))
+	assert.Empty(t, source)
+
+	forecast, source, err := p.QuerySource(weather.Query{City: "London"})
+	assert.Nil(t, err)
+	assert.Equal(t, "London", forecast.Name)
+	assert.Equal(t, file.Source, source)
+}
+
+func TestChaosWeatherSrc_Probabilities(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	upstream := providertest.Func(func(city string) (*weather.Forecast, error) {
+		return london, nil
+	})
+
+	run := func(seed int64) (errs, delays, corrupted int) {
+		p := NewWeatherSrc(upstream,
+			WithSeed(seed),
+			WithError(0.2, ErrTooManyRequests),
+			WithDelay(0.5, time.Millisecond),
+			WithCorruption(0.1),
+		)
+		p.sleep = func(time.Duration) { delays++ }
+		for i := 0; i < 1000; i++ {
+			_, err := p.GetForecast("London")
+			switch {
+			case isCorrupted(err):
+				corrupted++
+			case err != nil:
+				errs++
+			}
+		}
+		return errs, delays, corrupted
+	}
+
+	errs, delays, corrupted := run(1)
+	assert.InDelta(t, 200, errs, 50)
+	assert.InDelta(t, 500, delays, 60)
+	assert.InDelta(t, 80, corrupted, 30)
+
+	// the same seed gives the same faults
+	errs2, delays2, corrupted2 := run(1)
+	assert.Equal(t, []int{errs, delays, corrupted}, []int{errs2, delays2, corrupted2})
+}
+
+func TestChaosWeatherSrc_Errors(t *testing.T) {
+	upstream := providertest.Func(func(city string) (*weather.Forecast, error) {
+		return testutils.ForecastFromJSON("london.json"), nil
+	})
+	p := NewWeatherSrc(upstream, WithSeed(1), WithErrors(1, ErrTooManyRequests, ErrTimeout, ErrMisconfigured))
+
+	// every call fails, with each of the errors
+	seen := map[error]int{}
+	for i := 0; i < 300; i++ {
+		_, err := p.GetForecast("London")
+		if assert.NotNil(t, err) {
+			seen[err]++
+		}
+	}
+	assert.Len(t, seen, 3)
+	for err, n := range seen {
+		assert.InDelta(t, 100, n, 30, "%v", err)
+	}
+}
+
+func TestWriteableChaosWeatherSrc(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	storage := NewWriteableWeatherSrc(cache.NewWeatherSrc(), WithScript(
+		Fault{Err: weather.ErrUnavailable},
+		Fault{},
+		Fault{Corrupt: true},
+	))
+
+	assert.Equal(t, weather.ErrUnavailable, storage.SaveForecast("London", london))
+	assert.Nil(t, storage.SaveForecast("London", london))
+
+	_, err := storage.GetForecast("London")
+	assert.True(t, isCorrupted(err), err)
+
+	entries, err := storage.ListForecasts()
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestChaosWeatherSrc_Manager(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	external := NewWeatherSrc(providertest.Func(func(city string) (*weather.Forecast, error) {
+		return london, nil
+	}), WithScript(Fault{Err: ErrTooManyRequests}, Fault{Corrupt: true}))
+
+	manager := weathersrc.NewForecastManager(
+		weathersrc.WithExternalProvider(external),
+		weathersrc.WithStorageProvider(cache.NewWeatherSrc()),
+	)
+
+	// rate limit reaches the caller with its retry time and isn't cached
+	_, err := manager.GetForecasts("London")
+	var retryErr *weather.RetryAfterError
+	assert.True(t, errors.As(err, &retryErr))
+	assert.Equal(t, time.Second, retryErr.RetryAfter)
+
+	// corrupted response fails like broken upstream data and isn't cached either
+	_, err = manager.GetForecasts("London")
+	assert.True(t, isCorrupted(err), err)
+
+	forecasts, err := manager.GetForecasts("London")
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecasts.Cities["London"])
+}
+
+func TestChaosWeatherSrc_Contract(t *testing.T) {
+	// without faults the decorator is transparent
+	providertest.TestForecastProvider(t, NewWeatherSrc(file.NewWeatherSrc(file.WithDirPath("../../testdata/source"))), "London", "Atlantis")
+
+	providertest.TestWriteableForecastProvider(t, func(t *testing.T, clock *providertest.Clock, ttl time.Duration) weathersrc.WriteableForecastProvider {
+		return NewWriteableWeatherSrc(cache.NewWeatherSrc(cache.WithTTL(ttl), cache.WithClock(clock.Now)))
+	}, testutils.ForecastFromJSON("london.json"))
+
+	london := providertest.Func(func(city string) (*weather.Forecast, error) {
+		return testutils.ForecastFromJSON("london.json"), nil
+	})
+	providertest.TestErrors(t, "London", map[error]weathersrc.ForecastProvider{
+		weather.ErrTooManyRequests: NewWeatherSrc(london, WithError(1, ErrTooManyRequests)),
+		weather.ErrMisconfigured:   NewWeatherSrc(london, WithError(1, ErrMisconfigured)),
+		weather.ErrTimeout:         NewWeatherSrc(london, WithError(1, ErrTimeout)),
+	})
+}
+
+// isCorrupted tells if err is the one decoding broken response gives
+func isCorrupted(err error) bool {
+	var syntaxErr *json.SyntaxError
+	return errors.As(err, &syntaxErr)
+}
@@ -0,0 +1,58 @@
+package weathersrc_test
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/papisz/weather/weathersrc"
+	_ "github.com/papisz/weather/weathersrc/cache"
+	_ "github.com/papisz/weather/weathersrc/disk"
+	_ "github.com/papisz/weather/weathersrc/file"
+	_ "github.com/papisz/weather/weathersrc/openweather"
+	_ "github.com/papisz/weather/weathersrc/synthetic"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestConfigKeys_Declared checks providers read only settings declared in
+// their ConfigKeys, other ones could never be set from config
+func TestConfigKeys_Declared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// required settings are set, so factories don't stop before reading all
+	settings := weathersrc.Settings{"dir": dir, "api_key": "key"}
+
+	build := func(t *testing.T, keys weathersrc.ConfigKeys, newProvider func() (interface{}, error)) {
+		read := weathersrc.SettingsRead(func() {
+			p, err := newProvider()
+			assert.Nil(t, err)
+			if c, ok := p.(io.Closer); ok {
+				c.Close()
+			}
+		})
+		assert.NotEmpty(t, read)
+		for _, key := range read {
+			assert.Contains(t, keys, key, "setting %s is read, but not in ConfigKeys", key)
+		}
+	}
+
+	assert.NotEmpty(t, weathersrc.ExternalProviders())
+	for _, name := range weathersrc.ExternalProviders() {
+		t.Run(name, func(t *testing.T) {
+			build(t, weathersrc.ExternalConfigKeys(name), func() (interface{}, error) {
+				return weathersrc.NewExternal(name, settings)
+			})
+		})
+	}
+	assert.NotEmpty(t, weathersrc.StorageProviders())
+	for _, name := range weathersrc.StorageProviders() {
+		t.Run(name, func(t *testing.T) {
+			build(t, weathersrc.StorageConfigKeys(name), func() (interface{}, error) {
+				return weathersrc.NewStorage(name, settings)
+			})
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package disk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+)
+
+// Source is the name under which disk storage reports its entries
+const Source = "disk"
+
+const fileExt = ".json"
+
+// maxNameLen is the longest file name most file systems accept
+const maxNameLen = 255
+
+// DiskWeatherSrc is a storage keeping forecasts in files, so they survive restarts.
+// Every city is stored in a separate file together with its expiry time.
+type DiskWeatherSrc struct {
+	path string
+	ttl  time.Duration
+
+	mu   sync.RWMutex
+	now  func() time.Time
+	stop chan struct{}
+}
+
+type record struct {
+	City      string            `json:"city"`
+	StoredAt  time.Time         `json:"stored_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	Forecast  *weather.Forecast `json:"forecast"`
+}
+
+type Option func(provider *DiskWeatherSrc)
+
+func NewWeatherSrc(opts ...Option) *DiskWeatherSrc {
+	provider := &DiskWeatherSrc{
+		now: time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(provider)
+	}
+
+	return provider
+}
+
+// WithDirPath sets directory where forecasts are stored. It's created if it doesn't exist.
+func WithDirPath(path string) Option {
+	return func(provider *DiskWeatherSrc) {
+		provider.path = path
+	}
+}
+
+func WithTTL(ttl time.Duration) Option {
+	return func(provider *DiskWeatherSrc) {
+		provider.ttl = ttl
+	}
+}
+
+// WithCompactionInterval starts periodic removal of expired forecasts. It's
+// disabled if interval isn't positive, like TTL of forecasts which never expire.
+func WithCompactionInterval(interval time.Duration) Option {
+	return func(provider *DiskWeatherSrc) {
+		provider.startCompaction(interval)
+	}
+}
+
+// SetTTL changes how long forecasts saved from now on are kept
+func (p *DiskWeatherSrc) SetTTL(ttl time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ttl = ttl
+}
+
+// SetCompactionInterval restarts periodic compaction with a new interval, it's
+// stopped if interval isn't positive
+func (p *DiskWeatherSrc) SetCompactionInterval(interval time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.startCompaction(interval)
+}
+
+func (p *DiskWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rec, err := p.read(p.filePath(city))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, weather.ErrForecastNotFound
+		}
+		return nil, err
+	}
+
+	if p.expired(rec) {
+		return nil, weather.ErrForecastNotFound
+	}
+	return rec.Forecast, nil
+}
+
+func (p *DiskWeatherSrc) SaveForecast(city string, forecast *weather.Forecast) error {
+	return p.SaveForecastUntil(city, forecast, time.Time{})
+}
+
+// SaveForecastUntil saves forecast which expires at expiresAt, unless TTL
+// runs out earlier
+func (p *DiskWeatherSrc) SaveForecastUntil(city string, forecast *weather.Forecast, expiresAt time.Time) error {
+	p.mu.RLock()
+	ttl := p.ttl
+	p.mu.RUnlock()
+
+	rec := record{
+		City:     city,
+		StoredAt: p.now(),
+		Forecast: forecast,
+	}
+	if ttl > 0 {
+		rec.ExpiresAt = rec.StoredAt.Add(ttl)
+	}
+	if !expiresAt.IsZero() && (rec.ExpiresAt.IsZero() || expiresAt.Before(rec.ExpiresAt)) {
+		rec.ExpiresAt = expiresAt
+	}
+
+	jsonBytes, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("unable to marshal forecast: %v", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := os.MkdirAll(p.path, 0755); err != nil {
+		return fmt.Errorf("unable to create storage dir: %v", err)
+	}
+
+	// write to a temporary file first, so readers never see a partial file
+	tmp, err := ioutil.TempFile(p.path, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("unable to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(jsonBytes); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write file: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), p.filePath(city)); err != nil {
+		return fmt.Errorf("unable to write file: %v", err)
+	}
+	return nil
+}
+
+func (p *DiskWeatherSrc) DeleteForecast(city string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	filePath := p.filePath(city)
+	rec, err := p.read(filePath)
+	if err != nil && os.IsNotExist(err) {
+		return weather.ErrForecastNotFound
+	}
+
+	if err := os.Remove(filePath); err != nil {
+		return fmt.Errorf("unable to remove file: %v", err)
+	}
+	if rec != nil && p.expired(rec) {
+		return weather.ErrForecastNotFound
+	}
+	return nil
+}
+
+func (p *DiskWeatherSrc) GetEntry(city string) (weathersrc.Entry, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	rec, err := p.read(p.filePath(city))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return weathersrc.Entry{}, weather.ErrForecastNotFound
+		}
+		return weathersrc.Entry{}, err
+	}
+
+	if p.expired(rec) {
+		return weathersrc.Entry{}, weather.ErrForecastNotFound
+	}
+	return rec.toEntry(), nil
+}
+
+func (p *DiskWeatherSrc) ListForecasts() ([]weathersrc.Entry, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	entries := []weathersrc.Entry{}
+	err := p.walk(func(filePath string, rec *record, err error) error {
+		if err != nil || p.expired(rec) {
+			return nil
+		}
+		entries = append(entries, rec.toEntry())
+		return nil
+	})
+	return entries, err
+}
+
+func (p *DiskWeatherSrc) Flush() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.walk(func(filePath string, rec *record, err error) error {
+		if err := os.Remove(filePath); err != nil {
+			return fmt.Errorf("unable to remove file: %v", err)
+		}
+		return nil
+	})
+}
+
+// Compact removes expired forecasts from disk and returns number of removed entries
+func (p *DiskWeatherSrc) Compact() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	removed := 0
+	err := p.walk(func(filePath string, rec *record, err error) error {
+		// unreadable files would never be served, so they're removed too
+		if err == nil && !p.expired(rec) {
+			return nil
+		}
+		if err := os.Remove(filePath); err != nil {
+			return fmt.Errorf("unable to remove file: %v", err)
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+// walk calls fn for every forecast file with its record or error from reading it.
+// Caller must hold the lock.
+func (p *DiskWeatherSrc) walk(fn func(filePath string, rec *record, err error) error) error {
+	files, err := ioutil.ReadDir(p.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to read storage dir: %v", err)
+	}
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), fileExt) {
+			continue
+		}
+
+		filePath := path.Join(p.path, f.Name())
+		rec, err := p.read(filePath)
+		if err := fn(filePath, rec, err); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops periodic compaction
+func (p *DiskWeatherSrc) Close() error {
+	p.SetCompactionInterval(0)
+	return nil
+}
+
+// startCompaction stops compaction running with the previous interval
+func (p *DiskWeatherSrc) startCompaction(interval time.Duration) {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	p.stop = make(chan struct{})
+	go p.compactEvery(interval, p.stop)
+}
+
+func (p *DiskWeatherSrc) compactEvery(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if removed, err := p.Compact(); err != nil {
+				log.Printf("error compacting storage: %v", err)
+			} else if removed > 0 {
+				log.Printf("removed %d expired forecasts from storage", removed)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (p *DiskWeatherSrc) read(filePath string) (*record, error) {
+	jsonBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &record{}
+	if err := json.Unmarshal(jsonBytes, rec); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal bytes: %v", err)
+	}
+	return rec, nil
+}
+
+func (p *DiskWeatherSrc) expired(rec *record) bool {
+	return !rec.ExpiresAt.IsZero() && !p.now().Before(rec.ExpiresAt)
+}
+
+func (rec *record) toEntry() weathersrc.Entry {
+	return weathersrc.Entry{
+		City:      rec.City,
+		Source:    Source,
+		StoredAt:  rec.StoredAt,
+		ExpiresAt: rec.ExpiresAt,
+	}
+}
+
+// filePath escapes city name, so it can't point outside of storage dir. Names
+// too long for a file are hashed, records keep the original city anyway.
+func (p *DiskWeatherSrc) filePath(city string) string {
+	name := url.PathEscape(strings.ToLower(city)) + fileExt
+	if len(name) > maxNameLen {
+		sum := sha256.Sum256([]byte(strings.ToLower(city)))
+		name = hex.EncodeToString(sum[:]) + fileExt
+	}
+	return path.Join(p.path, name)
+}
+
+func init() {
+	weathersrc.RegisterStorage(Source, newFromSettings, weathersrc.ConfigKeys{
+		"dir":                 "storage_dir",
+		"ttl":                 "storage_ttl",
+		"compaction_interval": "storage_compaction_interval",
+	})
+}
+
+// newFromSettings builds storage from dir, ttl and compaction_interval,
+// which defaults to ttl
+func newFromSettings(s weathersrc.Settings) (weathersrc.WriteableForecastProvider, error) {
+	if s.String("dir") == "" {
+		return nil, errors.New("dir is required")
+	}
+	ttl, err := s.Duration("ttl")
+	if err != nil {
+		return nil, err
+	}
+	interval, err := s.Duration("compaction_interval")
+	if err != nil {
+		return nil, err
+	}
+	if interval == 0 {
+		interval = ttl
+	}
+
+	return NewWeatherSrc(WithDirPath(s.String("dir")), WithTTL(ttl), WithCompactionInterval(interval)), nil
+}
@@ -0,0 +1,164 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskWeatherSrc_GetSaveForecast(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	london := testutils.ForecastFromJSON("london.json")
+
+	p := NewWeatherSrc(WithDirPath(path.Join(dir, "storage")), WithTTL(time.Hour))
+	forecast, err := p.GetForecast("London")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+	assert.Nil(t, forecast)
+
+	assert.Nil(t, p.SaveForecast("London", london))
+
+	// a new instance reads what the previous one saved
+	p = NewWeatherSrc(WithDirPath(path.Join(dir, "storage")), WithTTL(time.Hour))
+	forecast, err = p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecast)
+
+	// city names can't escape storage dir
+	assert.Nil(t, p.SaveForecast("../London", london))
+	_, err = os.Stat(path.Join(dir, "london.json"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskWeatherSrc_LongCity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// every rune is escaped to 9 bytes, too many for a file name
+	city := strings.Repeat("北", 90) + "?lang=pl&units=metric"
+	london := testutils.ForecastFromJSON("london.json")
+
+	p := NewWeatherSrc(WithDirPath(dir), WithTTL(time.Hour))
+	_, err = p.GetForecast(city)
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+
+	assert.Nil(t, p.SaveForecast(city, london))
+	forecast, err := p.GetForecast(city)
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecast)
+
+	entries, err := p.ListForecasts()
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, city, entries[0].City)
+
+	assert.Nil(t, p.DeleteForecast(city))
+	_, err = p.GetForecast(city)
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+}
+
+func TestDiskWeatherSrc_Expiry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	p := NewWeatherSrc(WithDirPath(dir), WithTTL(time.Hour))
+	p.now = func() time.Time { return now }
+
+	assert.Nil(t, p.SaveForecast("London", testutils.ForecastFromJSON("london.json")))
+	now = now.Add(30 * time.Minute)
+	assert.Nil(t, p.SaveForecast("Warsaw", testutils.ForecastFromJSON("warsaw.json")))
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "broken.json"), []byte("{"), 0644))
+
+	now = now.Add(45 * time.Minute)
+	_, err = p.GetForecast("London")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+	_, err = p.GetForecast("Warsaw")
+	assert.Nil(t, err)
+
+	removed, err := p.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, removed)
+
+	files, _ := ioutil.ReadDir(dir)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "warsaw.json", files[0].Name())
+}
+
+func TestDiskWeatherSrc_SaveForecastUntil(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Now().UTC()
+	p := NewWeatherSrc(WithDirPath(dir), WithTTL(time.Hour))
+	p.now = func() time.Time { return now }
+
+	assert.Nil(t, p.SaveForecastUntil("London", testutils.ForecastFromJSON("london.json"), now.Add(time.Minute)))
+	assert.Nil(t, p.SaveForecastUntil("Warsaw", testutils.ForecastFromJSON("warsaw.json"), now.Add(2*time.Hour)))
+
+	entry, err := p.GetEntry("London")
+	assert.Nil(t, err)
+	assert.True(t, now.Add(time.Minute).Equal(entry.ExpiresAt))
+	entry, err = p.GetEntry("Warsaw")
+	assert.Nil(t, err)
+	assert.True(t, now.Add(time.Hour).Equal(entry.ExpiresAt))
+}
+
+func TestDiskWeatherSrc_CompactionInterval(t *testing.T) {
+	for _, interval := range []time.Duration{-time.Second, 0, time.Hour} {
+		t.Run(interval.String(), func(t *testing.T) {
+			p := NewWeatherSrc(WithCompactionInterval(interval))
+			assert.Equal(t, interval > 0, p.stop != nil)
+			assert.Nil(t, p.Close())
+		})
+	}
+}
+
+func TestDiskWeatherSrc_SetCompactionInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	p := NewWeatherSrc(WithDirPath(dir), WithCompactionInterval(time.Hour))
+	defer p.Close()
+	assert.Nil(t, ioutil.WriteFile(path.Join(dir, "broken.json"), []byte("{"), 0644))
+
+	// compaction restarted with a shorter interval removes the file right away
+	p.SetCompactionInterval(10 * time.Millisecond)
+	assert.Eventually(t, func() bool {
+		files, _ := ioutil.ReadDir(dir)
+		return len(files) == 0
+	}, time.Second, 10*time.Millisecond)
+
+	p.SetCompactionInterval(0)
+	assert.Nil(t, p.stop)
+}
+
+func TestDiskWeatherSrc_Contract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	n := 0
+	providertest.TestWriteableForecastProvider(t, func(t *testing.T, clock *providertest.Clock, ttl time.Duration) weathersrc.WriteableForecastProvider {
+		n++
+		p := NewWeatherSrc(WithDirPath(path.Join(dir, strconv.Itoa(n))), WithTTL(ttl))
+		p.now = clock.Now
+		return p
+	}, testutils.ForecastFromJSON("london.json"))
+}
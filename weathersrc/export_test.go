@@ -0,0 +1,11 @@
+package weathersrc
+
+// SettingsRead returns keys of settings read while build runs
+func SettingsRead(build func()) []string {
+	var keys []string
+	settingRead = func(key string) { keys = append(keys, key) }
+	defer func() { settingRead = func(key string) {} }()
+
+	build()
+	return keys
+}
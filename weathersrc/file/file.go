@@ -1,17 +1,29 @@
 package file
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path"
 	"strings"
 
 	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
 )
 
-// FileWeatherSrc is a weather source just for testing purposes.
+// Source names forecasts served from files, in registry and in responses
+const Source = "file"
+
+// maxNameLen is the longest file name most file systems accept
+const maxNameLen = 255
+
+// FileWeatherSrc serves forecasts from <city>.json files, like the ones in
+// testdata/source. It's used in tests and to run the service offline.
 type FileWeatherSrc struct {
 	path string
 }
@@ -35,14 +47,16 @@ func WithDirPath(path string) Option {
 	}
 }
 
-func (p *FileWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
-	jsonFile, err := os.Open(path.Join(p.path, strings.ToLower(city)+".json"))
-	if err != nil {
-		return nil, weather.ErrForecastNotFound
-	}
+func (p *FileWeatherSrc) Name() string {
+	return Source
+}
 
-	jsonBytes, err := ioutil.ReadAll(jsonFile)
+func (p *FileWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	jsonBytes, err := ioutil.ReadFile(filePath(p.path, city))
 	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, weather.ErrForecastNotFound
+		}
 		return nil, fmt.Errorf("unable to read file: %v", err)
 	}
 
@@ -52,3 +66,23 @@ func (p *FileWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
 	}
 	return forecast, nil
 }
+
+// filePath returns path of the file for city. City is escaped, so it can't
+// point outside of dir, and hashed if it's too long for a file name.
+func filePath(dir, city string) string {
+	name := url.PathEscape(strings.ToLower(city)) + ".json"
+	if len(name) > maxNameLen {
+		sum := sha256.Sum256([]byte(strings.ToLower(city)))
+		name = hex.EncodeToString(sum[:]) + ".json"
+	}
+	return path.Join(dir, name)
+}
+
+func init() {
+	weathersrc.RegisterExternal(Source, func(s weathersrc.Settings) (weathersrc.ForecastProvider, error) {
+		if s.String("dir") == "" {
+			return nil, errors.New("dir is required")
+		}
+		return NewWeatherSrc(WithDirPath(s.String("dir"))), nil
+	}, weathersrc.ConfigKeys{"dir": "file_dir"})
+}
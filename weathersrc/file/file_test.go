@@ -0,0 +1,73 @@
+package file
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileWeatherSrc_GetForecast(t *testing.T) {
+	p := NewWeatherSrc(WithDirPath("../../testdata/source"))
+
+	forecast, err := p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, testutils.ForecastFromJSON("london.json"), forecast)
+
+	_, err = p.GetForecast("Atlantis")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+
+	// city names can't escape fixture dir
+	_, err = p.GetForecast("../source/london")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+
+	// names too long for a file aren't an error
+	_, err = p.GetForecast(strings.Repeat("北", 90))
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "weather")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	london := testutils.ForecastFromJSON("london.json")
+	upstreamErr := errors.New("upstream failed")
+	r := NewRecorder(providertest.Func(func(city string) (*weather.Forecast, error) {
+		if city == "London" {
+			return london, nil
+		}
+		return nil, upstreamErr
+	}), dir)
+
+	forecast, err := r.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecast)
+
+	_, err = r.GetForecast("Warsaw")
+	assert.Equal(t, upstreamErr, err)
+
+	// recorded forecasts are replayed by file provider
+	replay := NewWeatherSrc(WithDirPath(dir))
+	forecast, err = replay.GetForecast("london")
+	assert.Nil(t, err)
+	assert.Equal(t, london, forecast)
+
+	_, err = replay.GetForecast("Warsaw")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+
+	// recorded provider is reported as the source
+	_, source, err := NewRecorder(replay, dir).QuerySource(weather.Query{City: "London"})
+	assert.Nil(t, err)
+	assert.Equal(t, Source, source)
+}
+
+func TestFileWeatherSrc_Contract(t *testing.T) {
+	providertest.TestForecastProvider(t, NewWeatherSrc(WithDirPath("../../testdata/source")), "London", "Atlantis")
+}
@@ -0,0 +1,79 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+)
+
+// Recorder wraps external provider and writes every forecast it returns to
+// dir, in the layout FileWeatherSrc reads, so it can be replayed offline.
+// Forecasts are recorded by location, so the last units and language win.
+type Recorder struct {
+	provider weathersrc.ForecastProvider
+	path     string
+}
+
+func NewRecorder(provider weathersrc.ForecastProvider, dir string) *Recorder {
+	return &Recorder{provider: provider, path: dir}
+}
+
+// Name returns name of recorded provider
+func (r *Recorder) Name() string {
+	return weathersrc.SourceName(r.provider)
+}
+
+func (r *Recorder) GetForecast(city string) (*weather.Forecast, error) {
+	return r.QueryForecast(weather.Query{City: city})
+}
+
+func (r *Recorder) QueryForecast(q weather.Query) (*weather.Forecast, error) {
+	forecast, _, err := r.QuerySource(q)
+	return forecast, err
+}
+
+// QuerySource fetches forecast from wrapped provider and records it.
+// Recording errors are only logged, they don't fail the request.
+func (r *Recorder) QuerySource(q weather.Query) (*weather.Forecast, string, error) {
+	forecast, source, err := weathersrc.Fetch(r.provider, q)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := r.record(q.Location(), forecast); err != nil {
+		log.Printf("unable to record forecast for %s: %v", q.Location(), err)
+	}
+	return forecast, source, nil
+}
+
+func (r *Recorder) record(city string, forecast *weather.Forecast) error {
+	jsonBytes, err := json.MarshalIndent(forecast, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to marshal forecast: %v", err)
+	}
+
+	if err := os.MkdirAll(r.path, 0755); err != nil {
+		return fmt.Errorf("unable to create dir: %v", err)
+	}
+
+	// write to a temporary file first, so replay never reads a partial file
+	tmp, err := ioutil.TempFile(r.path, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("unable to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(jsonBytes); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write file: %v", err)
+	}
+	return os.Rename(tmp.Name(), filePath(r.path, city))
+}
@@ -4,23 +4,29 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/papisz/weather"
+	"github.com/papisz/weather/history"
 )
 
 type ForecastManager interface {
 	GetForecasts(cities ...string) (*weather.Forecasts, error)
+	QueryForecasts(queries ...weather.Query) (*weather.Forecasts, error)
 }
 
 type ForecastManagerImpl struct {
 	externalProvider ForecastProvider
 	storageProvider  WriteableForecastProvider
+	historyStore     history.Store
+	now              func() time.Time
 }
 
 type Option func(o *ForecastManagerImpl)
 
 func NewForecastManager(opts ...Option) *ForecastManagerImpl {
-	manager := &ForecastManagerImpl{}
+	manager := &ForecastManagerImpl{now: time.Now}
 	for _, o := range opts {
 		o(manager)
 	}
@@ -39,42 +45,266 @@ func WithStorageProvider(provider WriteableForecastProvider) Option {
 	}
 }
 
+// WithHistoryStore archives every forecast fetched from external provider
+func WithHistoryStore(store history.Store) Option {
+	return func(m *ForecastManagerImpl) {
+		m.historyStore = store
+	}
+}
+
+// WithClock sets function returning current time, used to tell forecast age
+func WithClock(now func() time.Time) Option {
+	return func(m *ForecastManagerImpl) {
+		m.now = now
+	}
+}
+
 type ForecastProvider interface {
 	GetForecast(city string) (*weather.Forecast, error)
 }
 
+// NamedProvider is implemented by providers which can tell where forecasts
+// come from, reported as source of fetched forecasts
+type NamedProvider interface {
+	Name() string
+}
+
+// SourceName returns name of provider, or "external" if it can't tell
+func SourceName(provider ForecastProvider) string {
+	if named, ok := provider.(NamedProvider); ok {
+		return named.Name()
+	}
+	return "external"
+}
+
+// QueryForecastProvider is implemented by providers supporting query options, like language
+type QueryForecastProvider interface {
+	QueryForecast(q weather.Query) (*weather.Forecast, error)
+}
+
+// SourcedForecastProvider is implemented by providers passing queries on to
+// others, like a chain, which know which provider answered
+type SourcedForecastProvider interface {
+	// QuerySource returns forecast with name of the provider it comes from
+	QuerySource(q weather.Query) (*weather.Forecast, string, error)
+}
+
+// Fetch asks provider for forecast, passing query options if it supports
+// them, and returns name of the provider which answered. Providers without
+// query support get only plain city queries, weather.ErrUnsupportedQuery is
+// returned for the other ones, so they're never stored as converted.
+func Fetch(provider ForecastProvider, q weather.Query) (*weather.Forecast, string, error) {
+	switch p := provider.(type) {
+	case SourcedForecastProvider:
+		return p.QuerySource(q)
+	case QueryForecastProvider:
+		forecast, err := p.QueryForecast(q)
+		return forecast, SourceName(provider), err
+	}
+	switch {
+	case q.City == "":
+		return nil, "", unsupported(provider, "id or coordinates")
+	case q.Units != "":
+		return nil, "", unsupported(provider, "units")
+	case q.Lang != "":
+		return nil, "", unsupported(provider, "lang")
+	}
+	forecast, err := provider.GetForecast(q.City)
+	return forecast, SourceName(provider), err
+}
+
+func unsupported(provider ForecastProvider, option string) error {
+	return fmt.Errorf("%w by %s provider: %s", weather.ErrUnsupportedQuery, SourceName(provider), option)
+}
+
 type WriteableForecastProvider interface {
 	ForecastProvider
 	SaveForecast(city string, forecast *weather.Forecast) error
+	// DeleteForecast returns weather.ErrForecastNotFound if there was nothing to delete
+	DeleteForecast(city string) error
+	// ListForecasts returns entries which haven't expired yet
+	ListForecasts() ([]Entry, error)
+	Flush() error
+}
+
+// DeleteCity removes forecast stored for city together with its variants in
+// other units and languages, stored under weather.Query.Key. It returns
+// weather.ErrForecastNotFound if there was nothing to delete.
+func DeleteCity(storage WriteableForecastProvider, city string) error {
+	err := storage.DeleteForecast(city)
+	if err != nil && !errors.Is(err, weather.ErrForecastNotFound) {
+		return err
+	}
+	deleted := err == nil
+
+	entries, err := storage.ListForecasts()
+	if err != nil {
+		return err
+	}
+	prefix := strings.ToLower(city) + "?"
+	for _, e := range entries {
+		if !strings.HasPrefix(strings.ToLower(e.City), prefix) {
+			continue
+		}
+		err := storage.DeleteForecast(e.City)
+		if err != nil && !errors.Is(err, weather.ErrForecastNotFound) {
+			return err
+		}
+		deleted = deleted || err == nil
+	}
+
+	if !deleted {
+		return weather.ErrForecastNotFound
+	}
+	return nil
+}
+
+// EntryProvider is implemented by storages which can describe a single stored forecast
+type EntryProvider interface {
+	// GetEntry returns weather.ErrForecastNotFound if there is no valid entry for city
+	GetEntry(city string) (Entry, error)
+}
+
+// ExpiringStorage is implemented by storages which can keep a forecast for
+// shorter than their TTL, like when it's copied from another storage
+type ExpiringStorage interface {
+	// SaveForecastUntil saves forecast expiring at expiresAt or when storage
+	// TTL runs out, whichever comes first. Zero expiresAt leaves only the TTL.
+	SaveForecastUntil(city string, forecast *weather.Forecast, expiresAt time.Time) error
+}
+
+// Entry describes forecast kept in a storage
+type Entry struct {
+	City      string    `json:"city"`
+	Source    string    `json:"source"` // name of the storage keeping the forecast
+	StoredAt  time.Time `json:"stored_at"`
+	ExpiresAt time.Time `json:"expires_at"` // zero if forecast never expires
 }
 
 // GetForecasts returns forecasts for list of cities
 func (m *ForecastManagerImpl) GetForecasts(cities ...string) (*weather.Forecasts, error) {
+	queries := make([]weather.Query, 0, len(cities))
+	for _, city := range cities {
+		queries = append(queries, weather.Query{City: city})
+	}
+	return m.QueryForecasts(queries...)
+}
+
+// QueryForecasts returns forecasts for list of queries, keyed by location.
+// Stored forecasts older than query MaxAge are fetched again. If that fails,
+// the stored one is returned and marked as stale. Queries with Refresh don't
+// use stored forecasts at all.
+func (m *ForecastManagerImpl) QueryForecasts(queries ...weather.Query) (*weather.Forecasts, error) {
 	forecasts := weather.NewForecasts()
+	keys := make([]string, 0, len(queries))
 
-	for _, city := range cities {
+	for _, q := range queries {
+		key := q.Key()
 		var forecast *weather.Forecast
+		var freshness *weather.Freshness
 		var err error
-
-		if forecast, err = m.storageProvider.GetForecast(city); err != nil {
-			if !errors.Is(err, weather.ErrForecastNotFound) {
-				return nil, fmt.Errorf("error fetching forecast from storage for %s: %w", city, err)
+		if !q.Refresh {
+			if forecast, freshness, err = m.stored(key); err != nil {
+				return nil, fmt.Errorf("error fetching forecast from storage for %s: %w", q.Location(), err)
 			}
+		}
 
-			log.Printf("cache miss for %s", city)
-
-			if forecast, err = m.externalProvider.GetForecast(city); err != nil {
-				return nil, fmt.Errorf("error fetching forecast from external provider for %s: %w", city, err)
+		switch {
+		case forecast == nil:
+			if q.Refresh {
+				log.Printf("refresh forced for %s", key)
+			} else {
+				log.Printf("cache miss for %s", key)
 			}
-
-			if err := m.storageProvider.SaveForecast(city, forecast); err != nil {
-				return nil, fmt.Errorf("error saving forecast for %s: %w", city, err)
+			if forecast, freshness, err = m.refresh(q); err != nil {
+				return nil, err
+			}
+		case q.MaxAge > 0 && m.now().Sub(freshness.FetchedAt) > q.MaxAge:
+			log.Printf("cache hit for %s older than %s", key, q.MaxAge)
+			if fresh, f, err := m.refresh(q); err != nil {
+				log.Printf("serving stale forecast for %s: %v", key, err)
+				freshness.Stale = true
+			} else {
+				forecast, freshness = fresh, f
 			}
-		} else {
-			log.Printf("cache hit for %s", city)
+		default:
+			log.Printf("cache hit for %s", key)
 		}
-		forecasts.Cities[city] = forecast
 
+		freshness.AgeSeconds = int64(m.now().Sub(freshness.FetchedAt).Seconds())
+		forecasts.Cities[q.Location()] = forecast
+		forecasts.Meta[q.Location()] = freshness
+		keys = append(keys, key)
 	}
+
+	forecasts.ExpiresAt = m.expiresAt(keys)
 	return forecasts, nil
 }
+
+// stored returns forecast from storage, or nil if there is none. Forecast is
+// considered fetched when it was stored, or at its measurement time if
+// storage can't tell.
+func (m *ForecastManagerImpl) stored(key string) (*weather.Forecast, *weather.Freshness, error) {
+	forecast, err := m.storageProvider.GetForecast(key)
+	if err != nil {
+		if errors.Is(err, weather.ErrForecastNotFound) {
+			return nil, nil, nil
+		}
+		return nil, nil, err
+	}
+
+	freshness := &weather.Freshness{
+		FetchedAt: time.Unix(int64(forecast.Dt), 0),
+		Source:    "storage",
+		CacheHit:  true,
+	}
+	if entries, ok := m.storageProvider.(EntryProvider); ok {
+		if entry, err := entries.GetEntry(key); err == nil {
+			freshness.FetchedAt = entry.StoredAt
+			freshness.Source = entry.Source
+		}
+	}
+	return forecast, freshness, nil
+}
+
+// refresh fetches forecast from external provider, saves and archives it
+func (m *ForecastManagerImpl) refresh(q weather.Query) (*weather.Forecast, *weather.Freshness, error) {
+	forecast, source, err := Fetch(m.externalProvider, q)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching forecast from external provider for %s: %w", q.Location(), err)
+	}
+	freshness := &weather.Freshness{
+		FetchedAt: m.now(),
+		Source:    source,
+	}
+
+	if err := m.storageProvider.SaveForecast(q.Key(), forecast); err != nil {
+		return nil, nil, fmt.Errorf("error saving forecast for %s: %w", q.Location(), err)
+	}
+	if m.historyStore != nil {
+		if err := m.historyStore.Append(q.Location(), forecast); err != nil {
+			log.Printf("unable to archive forecast for %s: %v", q.Location(), err)
+		}
+	}
+	return forecast, freshness, nil
+}
+
+// expiresAt returns when the first of keys expires in storage, or zero if storage can't tell
+func (m *ForecastManagerImpl) expiresAt(keys []string) time.Time {
+	entries, ok := m.storageProvider.(EntryProvider)
+	if !ok {
+		return time.Time{}
+	}
+
+	var expiresAt time.Time
+	for _, key := range keys {
+		entry, err := entries.GetEntry(key)
+		if err != nil {
+			return time.Time{}
+		}
+		if expiresAt.IsZero() || (!entry.ExpiresAt.IsZero() && entry.ExpiresAt.Before(expiresAt)) {
+			expiresAt = entry.ExpiresAt
+		}
+	}
+	return expiresAt
+}
@@ -1,9 +1,12 @@
 package weathersrc
 
 import (
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/papisz/weather"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -80,6 +83,264 @@ func TestForecastManagerImpl_GetForecasts(t *testing.T) {
 	}
 }
 
+func TestForecastManagerImpl_QueryForecasts(t *testing.T) {
+	externalProvider := new(MockQueryProvider)
+	externalProvider.On("QueryForecast", weather.Query{City: "london", Lang: "pl"}).Return(&weather.Forecast{Name: "Londyn"}, nil).Once()
+	externalProvider.On("QueryForecast", weather.Query{City: "london"}).Return(&weather.Forecast{Name: "London"}, nil).Once()
+	storageProvider := newMockStorage()
+
+	m := NewForecastManager(
+		WithExternalProvider(externalProvider),
+		WithStorageProvider(storageProvider),
+	)
+
+	for i := 0; i < 2; i++ {
+		forecasts, err := m.QueryForecasts(weather.Query{City: "london", Lang: "pl"})
+		assert.Nil(t, err)
+		assert.Equal(t, "Londyn", forecasts.Cities["london"].Name)
+
+		forecasts, err = m.GetForecasts("london")
+		assert.Nil(t, err)
+		assert.Equal(t, "London", forecasts.Cities["london"].Name)
+	}
+
+	externalProvider.AssertExpectations(t)
+	assert.Len(t, storageProvider.forecasts, 2)
+}
+
+func TestForecastManagerImpl_Freshness(t *testing.T) {
+	now := time.Date(2020, 4, 27, 19, 42, 17, 0, time.UTC)
+	london := &weather.Forecast{Name: "London"}
+
+	tests := []struct {
+		name          string
+		storedAgo     time.Duration // forecast isn't stored if zero
+		external      ForecastProvider
+		maxAge        time.Duration
+		refresh       bool
+		wantFreshness weather.Freshness
+	}{
+		{
+			name:          "Cache miss",
+			external:      &namedProvider{forecast: london},
+			wantFreshness: weather.Freshness{FetchedAt: now, Source: "named"},
+		},
+		{
+			name:          "Cache miss reports provider which answered",
+			external:      &sourcedProvider{forecast: london, source: "file"},
+			wantFreshness: weather.Freshness{FetchedAt: now, Source: "file"},
+		},
+		{
+			name:          "Cache hit",
+			storedAgo:     90 * time.Second,
+			external:      &namedProvider{forecast: london},
+			wantFreshness: weather.Freshness{FetchedAt: now.Add(-90 * time.Second), AgeSeconds: 90, Source: "mock", CacheHit: true},
+		},
+		{
+			name:          "Cache hit older than max age is refreshed",
+			storedAgo:     90 * time.Second,
+			external:      &namedProvider{forecast: london},
+			maxAge:        time.Minute,
+			wantFreshness: weather.Freshness{FetchedAt: now, Source: "named"},
+		},
+		{
+			name:          "Cache hit within max age",
+			storedAgo:     30 * time.Second,
+			external:      &namedProvider{forecast: london},
+			maxAge:        time.Minute,
+			wantFreshness: weather.Freshness{FetchedAt: now.Add(-30 * time.Second), AgeSeconds: 30, Source: "mock", CacheHit: true},
+		},
+		{
+			name:          "Forced refresh",
+			storedAgo:     10 * time.Second,
+			external:      &sourcedProvider{forecast: london, source: "file"},
+			refresh:       true,
+			wantFreshness: weather.Freshness{FetchedAt: now, Source: "file"},
+		},
+		{
+			name:          "Stale cache hit if refresh fails",
+			storedAgo:     70 * time.Second,
+			external:      &namedProvider{err: weather.ErrUnavailable},
+			maxAge:        time.Minute,
+			wantFreshness: weather.Freshness{FetchedAt: now.Add(-70 * time.Second), AgeSeconds: 70, Source: "mock", CacheHit: true, Stale: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storageProvider := newMockStorage()
+			if tt.storedAgo > 0 {
+				storageProvider.forecasts["london"] = london
+				storageProvider.storedAt["london"] = now.Add(-tt.storedAgo)
+			}
+			m := NewForecastManager(
+				WithExternalProvider(tt.external),
+				WithStorageProvider(storageProvider),
+				WithClock(func() time.Time { return now }),
+			)
+
+			forecasts, err := m.QueryForecasts(weather.Query{City: "london", MaxAge: tt.maxAge, Refresh: tt.refresh})
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Equal(t, "London", forecasts.Cities["london"].Name)
+			assert.Equal(t, &tt.wantFreshness, forecasts.Meta["london"])
+		})
+	}
+}
+
+func TestFetch(t *testing.T) {
+	london := &weather.Forecast{Name: "London"}
+
+	tests := []struct {
+		name       string
+		provider   ForecastProvider
+		query      weather.Query
+		want       *weather.Forecast
+		wantSource string
+		wantErr    string
+	}{
+		{
+			name:       "City from named provider",
+			provider:   &namedProvider{forecast: london},
+			query:      weather.Query{City: "London"},
+			want:       london,
+			wantSource: "named",
+		},
+		{
+			name:     "Units from provider without query support",
+			provider: &namedProvider{forecast: london},
+			query:    weather.Query{City: "London", Units: "metric"},
+			wantErr:  "query not supported by named provider: units",
+		},
+		{
+			name:     "Language from provider without query support",
+			provider: &namedProvider{forecast: london},
+			query:    weather.Query{City: "London", Lang: "pl"},
+			wantErr:  "query not supported by named provider: lang",
+		},
+		{
+			name:       "Units from provider reporting source",
+			provider:   &sourcedProvider{forecast: london, source: "file"},
+			query:      weather.Query{City: "London", Units: "metric"},
+			want:       london,
+			wantSource: "file",
+		},
+		{
+			name:     "ID from provider without query support",
+			provider: &namedProvider{forecast: london},
+			query:    weather.Query{ID: 2643743},
+			wantErr:  "query not supported by named provider: id or coordinates",
+		},
+		{
+			name:     "Coordinates from provider without query support",
+			provider: &namedProvider{forecast: london},
+			query:    weather.Query{Coord: &weather.Coord{Lat: 51.51, Lon: -0.13}},
+			wantErr:  "query not supported by named provider: id or coordinates",
+		},
+		{
+			name:       "ID from provider reporting source",
+			provider:   &sourcedProvider{forecast: london, source: "file"},
+			query:      weather.Query{ID: 2643743},
+			want:       london,
+			wantSource: "file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, source, err := Fetch(tt.provider, tt.query)
+
+			if tt.wantErr != "" {
+				assert.True(t, errors.Is(err, weather.ErrUnsupportedQuery))
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantSource, source)
+		})
+	}
+}
+
+// namedProvider returns the same forecast, or error if it's set
+type namedProvider struct {
+	forecast *weather.Forecast
+	err      error
+}
+
+func (p *namedProvider) Name() string {
+	return "named"
+}
+
+func (p *namedProvider) GetForecast(city string) (*weather.Forecast, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.forecast, nil
+}
+
+// sourcedProvider reports forecast as coming from source, like a chain does
+type sourcedProvider struct {
+	forecast *weather.Forecast
+	source   string
+}
+
+func (p *sourcedProvider) Name() string {
+	return "sourced"
+}
+
+func (p *sourcedProvider) GetForecast(city string) (*weather.Forecast, error) {
+	return p.forecast, nil
+}
+
+func (p *sourcedProvider) QuerySource(q weather.Query) (*weather.Forecast, string, error) {
+	return p.forecast, p.source, nil
+}
+
+type MockQueryProvider struct {
+	MockProvider
+}
+
+func (m *MockQueryProvider) QueryForecast(q weather.Query) (*weather.Forecast, error) {
+	args := m.Called(q)
+	return args.Get(0).(*weather.Forecast), args.Error(1)
+}
+
+// mockStorage keeps forecasts in a map
+type mockStorage struct {
+	MockProvider
+	forecasts map[string]*weather.Forecast
+	storedAt  map[string]time.Time
+	now       func() time.Time
+}
+
+func newMockStorage() *mockStorage {
+	return &mockStorage{
+		forecasts: map[string]*weather.Forecast{},
+		storedAt:  map[string]time.Time{},
+		now:       time.Now,
+	}
+}
+
+func (m *mockStorage) GetEntry(city string) (Entry, error) {
+	if _, ok := m.forecasts[city]; !ok {
+		return Entry{}, weather.ErrForecastNotFound
+	}
+	return Entry{City: city, Source: "mock", StoredAt: m.storedAt[city]}, nil
+}
+
+func (m *mockStorage) GetForecast(city string) (*weather.Forecast, error) {
+	if forecast, ok := m.forecasts[city]; ok {
+		return forecast, nil
+	}
+	return nil, weather.ErrForecastNotFound
+}
+
+func (m *mockStorage) SaveForecast(city string, forecast *weather.Forecast) error {
+	m.forecasts[city] = forecast
+	m.storedAt[city] = m.now()
+	return nil
+}
+
 type MockProvider struct {
 	mock.Mock
 }
@@ -103,6 +364,18 @@ func (m *MockProvider) SaveForecast(city string, forecast *weather.Forecast) err
 	return nil
 }
 
+func (m *MockProvider) DeleteForecast(city string) error {
+	return nil
+}
+
+func (m *MockProvider) ListForecasts() ([]Entry, error) {
+	return nil, nil
+}
+
+func (m *MockProvider) Flush() error {
+	return nil
+}
+
 type returnedForecast struct {
 	forecast *weather.Forecast
 	err      error
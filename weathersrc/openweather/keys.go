@@ -0,0 +1,174 @@
+package openweather
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/papisz/weather"
+)
+
+// defaultBenchWindow is used when the API doesn't tell us when the limit resets.
+// OpenWeather limits calls per minute.
+const defaultBenchWindow = time.Minute
+
+// KeyState describes whether an API key can be used
+type KeyState string
+
+const (
+	// KeyActive means the key is used in rotation
+	KeyActive KeyState = "active"
+	// KeyBenched means the key exceeded its limit and waits for the window to reset
+	KeyBenched KeyState = "benched"
+	// KeyDisabled means the key was rejected by the API and won't be used again
+	KeyDisabled KeyState = "disabled"
+)
+
+// KeyStatus is a snapshot of a single API key state. Key is masked.
+type KeyStatus struct {
+	Key          string    `json:"key"`
+	State        KeyState  `json:"state"`
+	BenchedUntil time.Time `json:"benched_until,omitempty"`
+}
+
+type apiKey struct {
+	value        string
+	benchedUntil time.Time
+	disabled     bool
+}
+
+// keyPool rotates API keys round-robin, skipping benched and disabled ones.
+type keyPool struct {
+	mu          sync.Mutex
+	keys        []*apiKey
+	next        int
+	benchWindow time.Duration
+	now         func() time.Time
+}
+
+func newKeyPool(keys ...string) *keyPool {
+	pool := &keyPool{
+		benchWindow: defaultBenchWindow,
+		now:         time.Now,
+	}
+	pool.set(keys...)
+	return pool
+}
+
+// set replaces keys in rotation. Keys which stay keep their benched or
+// disabled state, so rotating one key doesn't revive the others.
+func (p *keyPool) set(keys ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var pooled []*apiKey
+	for _, k := range keys {
+		if k = strings.TrimSpace(k); k == "" {
+			continue
+		}
+		if existing := p.find(k); existing != nil {
+			pooled = append(pooled, existing)
+			continue
+		}
+		pooled = append(pooled, &apiKey{value: k})
+	}
+	p.keys = pooled
+	p.next = 0
+}
+
+func (p *keyPool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.keys)
+}
+
+// acquire returns next usable key. If there is none, it returns
+// ErrMisconfigured when all keys are disabled and ErrTooManyRequests, with time
+// until the first key is back, otherwise.
+func (p *keyPool) acquire() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	disabled := 0
+	var firstBack time.Time
+	for i := 0; i < len(p.keys); i++ {
+		k := p.keys[(p.next+i)%len(p.keys)]
+		if k.disabled {
+			disabled++
+			continue
+		}
+		if now.Before(k.benchedUntil) {
+			if firstBack.IsZero() || k.benchedUntil.Before(firstBack) {
+				firstBack = k.benchedUntil
+			}
+			continue
+		}
+		p.next = (p.next + i + 1) % len(p.keys)
+		return k.value, nil
+	}
+
+	if disabled == len(p.keys) {
+		return "", weather.ErrMisconfigured
+	}
+	return "", &weather.RetryAfterError{Err: weather.ErrTooManyRequests, RetryAfter: firstBack.Sub(now)}
+}
+
+// bench excludes key from rotation for given time, or for the default window if it's not positive
+func (p *keyPool) bench(key string, d time.Duration) {
+	if d <= 0 {
+		d = p.benchWindow
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k := p.find(key); k != nil {
+		k.benchedUntil = p.now().Add(d)
+	}
+}
+
+// disable excludes key from rotation permanently
+func (p *keyPool) disable(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k := p.find(key); k != nil {
+		k.disabled = true
+	}
+}
+
+func (p *keyPool) find(key string) *apiKey {
+	for _, k := range p.keys {
+		if k.value == key {
+			return k
+		}
+	}
+	return nil
+}
+
+func (p *keyPool) statuses() []KeyStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	statuses := make([]KeyStatus, 0, len(p.keys))
+	for _, k := range p.keys {
+		status := KeyStatus{Key: maskKey(k.value), State: KeyActive}
+		switch {
+		case k.disabled:
+			status.State = KeyDisabled
+		case now.Before(k.benchedUntil):
+			status.State = KeyBenched
+			status.BenchedUntil = k.benchedUntil
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// maskKey leaves only last 4 characters of the key visible
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return strings.Repeat("*", len(key))
+	}
+	return strings.Repeat("*", len(key)-4) + key[len(key)-4:]
+}
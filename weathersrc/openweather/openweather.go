@@ -2,18 +2,25 @@ package openweather
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
 )
 
+// Source is the name under which provider is registered and reports forecasts
+const Source = "openweather"
+
 type OpenWeatherSrc struct {
 	URL    string
-	apiKey string
+	keys   *keyPool
 	client HTTPClient
 }
 
@@ -24,7 +31,9 @@ type HTTPClient interface {
 type Option func(provider *OpenWeatherSrc)
 
 func NewWeatherSrc(opts ...Option) *OpenWeatherSrc {
-	provider := &OpenWeatherSrc{}
+	provider := &OpenWeatherSrc{
+		keys: newKeyPool(),
+	}
 
 	for _, opt := range opts {
 		opt(provider)
@@ -52,27 +61,101 @@ func WithURL(url string) Option {
 }
 
 func WithAPIKey(apiKey string) Option {
+	return WithAPIKeys(apiKey)
+}
+
+// WithAPIKeys sets a pool of API keys used round-robin. Empty keys are ignored.
+func WithAPIKeys(apiKeys ...string) Option {
+	return func(provider *OpenWeatherSrc) {
+		provider.keys.set(apiKeys...)
+	}
+}
+
+// WithKeyBenchWindow sets how long a key is benched after exceeding its limit,
+// if the API doesn't return Retry-After header
+func WithKeyBenchWindow(d time.Duration) Option {
 	return func(provider *OpenWeatherSrc) {
-		provider.apiKey = apiKey
+		provider.keys.benchWindow = d
 	}
 }
 
-func (p *OpenWeatherSrc) getURL(city string) string {
+// SetAPIKeys replaces the pool of API keys, it's safe to call while forecasts
+// are fetched. Keys already in the pool keep their state.
+func (p *OpenWeatherSrc) SetAPIKeys(apiKeys ...string) {
+	p.keys.set(apiKeys...)
+}
+
+// KeyStatuses returns state of every configured API key
+func (p *OpenWeatherSrc) KeyStatuses() []KeyStatus {
+	return p.keys.statuses()
+}
+
+func (p *OpenWeatherSrc) getURL(q weather.Query, apiKey string) string {
 	v := url.Values{}
-	v.Add("q", city)
-	v.Add("appid", p.apiKey)
+	switch {
+	case q.City != "":
+		v.Add("q", q.City)
+	case q.ID != 0:
+		v.Add("id", strconv.Itoa(q.ID))
+	case q.Coord != nil:
+		v.Add("lat", strconv.FormatFloat(q.Coord.Lat, 'f', -1, 64))
+		v.Add("lon", strconv.FormatFloat(q.Coord.Lon, 'f', -1, 64))
+	}
+	v.Add("appid", apiKey)
+	if q.Units != "" {
+		v.Add("units", q.Units)
+	}
+	if q.Lang != "" {
+		v.Add("lang", q.Lang)
+	}
 	return p.URL + "?" + v.Encode()
 }
 
+func (p *OpenWeatherSrc) Name() string {
+	return Source
+}
+
 func (p *OpenWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
-	req, err := http.NewRequest(http.MethodGet, p.getURL(city), nil)
+	return p.QueryForecast(weather.Query{City: city})
+}
+
+// QueryForecast fetches forecast using next available API key. Keys which
+// exceeded their limit or were rejected are taken out of rotation and the
+// request is retried with another key.
+func (p *OpenWeatherSrc) QueryForecast(q weather.Query) (*weather.Forecast, error) {
+	var err error
+
+	// the last attempt only asks the pool why there is no key left
+	for attempt := 0; attempt <= p.keys.len(); attempt++ {
+		var key string
+		if key, err = p.keys.acquire(); err != nil {
+			return nil, err
+		}
+
+		var forecast *weather.Forecast
+		forecast, err = p.fetch(q, key)
+		if errors.Is(err, weather.ErrTooManyRequests) || errors.Is(err, weather.ErrMisconfigured) {
+			continue
+		}
+		return forecast, err
+	}
+
+	return nil, err
+}
+
+func (p *OpenWeatherSrc) fetch(q weather.Query, apiKey string) (*weather.Forecast, error) {
+	req, err := http.NewRequest(http.MethodGet, p.getURL(q, apiKey), nil)
 	if err != nil {
-		return nil, err
+		return nil, redactError(err)
 	}
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, err
+		err = redactError(err)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return nil, fmt.Errorf("%w: %v", weather.ErrTimeout, err)
+		}
+		return nil, fmt.Errorf("%w: %v", weather.ErrUnavailable, err)
 	}
 
 	defer resp.Body.Close()
@@ -82,9 +165,14 @@ func (p *OpenWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
 	case http.StatusNotFound:
 		return nil, weather.ErrForecastNotFound
 	case http.StatusUnauthorized:
+		p.keys.disable(apiKey)
 		return nil, weather.ErrMisconfigured
 	case http.StatusTooManyRequests:
+		p.keys.bench(apiKey, retryAfter(resp.Header))
 		return nil, weather.ErrTooManyRequests
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusInternalServerError:
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: external service returned %d and %s", weather.ErrUnavailable, resp.StatusCode, body)
 	default:
 		body, _ := ioutil.ReadAll(resp.Body)
 		return nil, fmt.Errorf("external service returned %d and %s", resp.StatusCode, body)
@@ -97,3 +185,61 @@ func (p *OpenWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
 
 	return &forecast, nil
 }
+
+// redactError hides API key in URL carried by the error, errors end up in
+// logs and API responses
+func redactError(err error) error {
+	var urlErr *url.Error
+	if !errors.As(err, &urlErr) {
+		return err
+	}
+	return &url.Error{Op: urlErr.Op, URL: redactURL(urlErr.URL), Err: urlErr.Err}
+}
+
+// redactURL replaces value of appid query parameter
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "[unparseable URL]"
+	}
+	q := u.Query()
+	if q.Get("appid") != "" {
+		q.Set("appid", "[redacted]")
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// retryAfter parses Retry-After header given in seconds
+func retryAfter(h http.Header) time.Duration {
+	seconds, err := strconv.Atoi(h.Get("Retry-After"))
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func init() {
+	weathersrc.RegisterExternal(Source, newFromSettings, weathersrc.ConfigKeys{
+		"url":      "weather_src_api_url",
+		"api_key":  "weather_src_api_key",
+		"api_keys": "weather_src_api_keys",
+	})
+}
+
+// newFromSettings builds provider from url, api_key and comma-separated
+// api_keys, the single key is used first
+func newFromSettings(s weathersrc.Settings) (weathersrc.ForecastProvider, error) {
+	keys := s.List("api_keys")
+	if key := s.String("api_key"); key != "" {
+		keys = append([]string{key}, keys...)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("api_keys: at least one key is required")
+	}
+	opts := []Option{WithAPIKeys(keys...), WithDefaultClient()}
+	if apiURL := s.String("url"); apiURL != "" {
+		opts = append(opts, WithURL(apiURL))
+	}
+	return NewWeatherSrc(opts...), nil
+}
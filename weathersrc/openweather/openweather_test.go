@@ -4,13 +4,17 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/papisz/weather"
 	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/providertest"
 )
 
 func TestOpenWeatherSrc_GetForecast(t *testing.T) {
@@ -30,6 +34,18 @@ func TestOpenWeatherSrc_GetForecast(t *testing.T) {
 		wantErr     bool
 		expectedErr error
 	}{
+		{
+			name: "External service unavailable",
+			fields: fields{
+				apiKey: "fake",
+				status: http.StatusServiceUnavailable,
+				body:   []byte("maintenance"),
+			},
+			args:        args{city: "London"},
+			want:        nil,
+			wantErr:     true,
+			expectedErr: errors.New("external service unavailable: external service returned 503 and maintenance"),
+		},
 		{
 			name: "Proper response with forecast",
 			fields: fields{
@@ -125,3 +141,270 @@ func TestOpenWeatherSrc_GetForecast(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenWeatherSrc_KeyRotation(t *testing.T) {
+	var usedKeys []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("appid")
+		usedKeys = append(usedKeys, key)
+		switch key {
+		case "limited":
+			res.Header().Set("Retry-After", "30")
+			res.WriteHeader(http.StatusTooManyRequests)
+		case "revoked":
+			res.WriteHeader(http.StatusUnauthorized)
+		default:
+			res.Write(testutils.JSONFileToBytes("../../testdata/source", "london.json"))
+		}
+	}))
+	defer testServer.Close()
+
+	now := time.Now()
+	p := NewWeatherSrc(
+		WithURL(testServer.URL),
+		WithDefaultClient(),
+		WithAPIKeys("limited", "revoked", "", "good1", "good2"),
+	)
+	p.keys.now = func() time.Time { return now }
+
+	_, err := p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"limited", "revoked", "good1"}, usedKeys)
+
+	_, err = p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, "good2", usedKeys[len(usedKeys)-1])
+
+	assert.Equal(t, []KeyStatus{
+		{Key: "***ited", State: KeyBenched, BenchedUntil: now.Add(30 * time.Second)},
+		{Key: "***oked", State: KeyDisabled},
+		{Key: "*ood1", State: KeyActive},
+		{Key: "*ood2", State: KeyActive},
+	}, p.KeyStatuses())
+
+	// benched key comes back after its window
+	now = now.Add(time.Minute)
+	usedKeys = nil
+	p.GetForecast("London")
+	assert.Equal(t, []string{"limited", "good1"}, usedKeys)
+}
+
+func TestOpenWeatherSrc_AllKeysUnavailable(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		expectedErr    error
+		wantRetryAfter bool
+	}{
+		{
+			name:           "All keys rate limited, retry after the first one is back",
+			status:         http.StatusTooManyRequests,
+			expectedErr:    weather.ErrTooManyRequests,
+			wantRetryAfter: true,
+		},
+		{
+			name:        "All keys revoked",
+			status:      http.StatusUnauthorized,
+			expectedErr: weather.ErrMisconfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				calls++
+				res.WriteHeader(tt.status)
+			}))
+			defer testServer.Close()
+
+			p := NewWeatherSrc(
+				WithURL(testServer.URL),
+				WithDefaultClient(),
+				WithAPIKeys("key1", "key2"),
+			)
+
+			_, err := p.GetForecast("London")
+			assert.True(t, errors.Is(err, tt.expectedErr))
+			assert.Equal(t, 2, calls)
+
+			// no more requests while keys are unavailable
+			_, err = p.GetForecast("London")
+			assert.True(t, errors.Is(err, tt.expectedErr))
+			assert.Equal(t, 2, calls)
+
+			var retryErr *weather.RetryAfterError
+			if !tt.wantRetryAfter {
+				assert.False(t, errors.As(err, &retryErr))
+				return
+			}
+			if assert.True(t, errors.As(err, &retryErr)) {
+				assert.InDelta(t, time.Minute, retryErr.RetryAfter, float64(time.Second))
+			}
+		})
+	}
+}
+
+func TestOpenWeatherSrc_QueryForecastLang(t *testing.T) {
+	var lang []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		lang = append(lang, req.URL.Query().Get("lang"))
+		res.Write(testutils.JSONFileToBytes("../../testdata/source", "london.json"))
+	}))
+	defer testServer.Close()
+
+	p := NewWeatherSrc(
+		WithURL(testServer.URL),
+		WithDefaultClient(),
+		WithAPIKey("fake"),
+	)
+
+	_, err := p.QueryForecast(weather.Query{City: "London", Lang: "pl"})
+	assert.Nil(t, err)
+	_, err = p.GetForecast("London")
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"pl", ""}, lang)
+}
+
+func TestOpenWeatherSrc_QueryForecastParams(t *testing.T) {
+	tests := []struct {
+		name  string
+		query weather.Query
+		want  url.Values
+	}{
+		{
+			name:  "City",
+			query: weather.Query{City: "London"},
+			want:  url.Values{"q": {"London"}, "appid": {"fake"}},
+		},
+		{
+			name:  "ID with units",
+			query: weather.Query{ID: 2643743, Units: "metric"},
+			want:  url.Values{"id": {"2643743"}, "appid": {"fake"}, "units": {"metric"}},
+		},
+		{
+			name:  "Coordinates with units and language",
+			query: weather.Query{Coord: &weather.Coord{Lat: 51.5085, Lon: -0.1257}, Units: "imperial", Lang: "zh_cn"},
+			want:  url.Values{"lat": {"51.5085"}, "lon": {"-0.1257"}, "appid": {"fake"}, "units": {"imperial"}, "lang": {"zh_cn"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var params url.Values
+			testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				params = req.URL.Query()
+				res.Write(testutils.JSONFileToBytes("../../testdata/source", "london.json"))
+			}))
+			defer testServer.Close()
+
+			p := NewWeatherSrc(WithURL(testServer.URL), WithDefaultClient(), WithAPIKey("fake"))
+
+			_, err := p.QueryForecast(tt.query)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, params)
+		})
+	}
+}
+
+func TestOpenWeatherSrc_SetAPIKeys(t *testing.T) {
+	var usedKeys []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("appid")
+		usedKeys = append(usedKeys, key)
+		if key == "revoked" {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		res.Write(testutils.JSONFileToBytes("../../testdata/source", "london.json"))
+	}))
+	defer testServer.Close()
+
+	p := NewWeatherSrc(
+		WithURL(testServer.URL),
+		WithDefaultClient(),
+		WithAPIKeys("revoked", "old"),
+	)
+	_, err := p.GetForecast("London")
+	assert.Nil(t, err)
+
+	// revoked key stays disabled, new key joins the rotation
+	p.SetAPIKeys("new", "revoked")
+	assert.Equal(t, []KeyStatus{
+		{Key: "***", State: KeyActive},
+		{Key: "***oked", State: KeyDisabled},
+	}, p.KeyStatuses())
+
+	usedKeys = nil
+	_, err = p.GetForecast("London")
+	assert.Nil(t, err)
+	_, err = p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"new", "new"}, usedKeys)
+}
+
+func TestOpenWeatherSrc_RedactsAPIKey(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {}))
+	testServer.Close()
+
+	tests := []struct {
+		name string
+		url  string
+	}{
+		{name: "Connection error", url: testServer.URL},
+		{name: "Invalid URL", url: "http://[::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewWeatherSrc(
+				WithURL(tt.url),
+				WithDefaultClient(),
+				WithAPIKey("topsecretkey"),
+			)
+
+			_, err := p.GetForecast("London")
+			assert.NotNil(t, err)
+			assert.NotContains(t, err.Error(), "topsecretkey")
+		})
+	}
+}
+
+func TestOpenWeatherSrc_Contract(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("q") {
+		case "London":
+			res.Write(testutils.JSONFileToBytes("../../testdata/source", "london.json"))
+		case "Ratelimited":
+			res.WriteHeader(http.StatusTooManyRequests)
+		case "Revoked":
+			res.WriteHeader(http.StatusUnauthorized)
+		case "Down":
+			res.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			res.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	newProvider := func(url string) *OpenWeatherSrc {
+		return NewWeatherSrc(WithURL(url), WithDefaultClient(), WithAPIKey("key"))
+	}
+	providertest.TestForecastProvider(t, newProvider(testServer.URL), "London", "Atlantis")
+
+	// each provider gets a fresh key pool, as failures take keys out of rotation
+	byCity := func(city string) weathersrc.ForecastProvider {
+		return providertest.Func(func(string) (*weather.Forecast, error) {
+			return newProvider(testServer.URL).GetForecast(city)
+		})
+	}
+	providertest.TestErrors(t, "London", map[error]weathersrc.ForecastProvider{
+		weather.ErrForecastNotFound: byCity("Atlantis"),
+		weather.ErrTooManyRequests:  byCity("Ratelimited"),
+		weather.ErrMisconfigured:    byCity("Revoked"),
+		weather.ErrUnavailable:      byCity("Down"),
+		weather.ErrTimeout: NewWeatherSrc(
+			WithURL(testServer.URL),
+			WithCustomClient(&http.Client{Timeout: time.Nanosecond}),
+			WithAPIKey("key"),
+		),
+	})
+}
@@ -0,0 +1,322 @@
+// Package providertest checks that forecast providers follow the contract
+// the manager and the API rely on. Every provider package runs these checks
+// from its own tests.
+package providertest
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/stretchr/testify/assert"
+)
+
+// Clock is a fake time source, storages under test should read time from Now
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewClock() *Clock {
+	return &Clock{now: time.Date(2020, 4, 27, 19, 42, 17, 0, time.UTC)}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Func adapts a function to weathersrc.ForecastProvider, e.g. to stub
+// providers wrapped by the one under test
+type Func func(city string) (*weather.Forecast, error)
+
+func (f Func) GetForecast(city string) (*weather.Forecast, error) {
+	return f(city)
+}
+
+// NewStorage returns an empty storage keeping forecasts for ttl and reading
+// time from clock
+type NewStorage func(t *testing.T, clock *Clock, ttl time.Duration) weathersrc.WriteableForecastProvider
+
+// cities are keys exercising escaping in storages
+var cities = []string{"London", "london?lang=pl&units=metric", "New York", "Łódź", "../escape", "id:2643743", "51.51,-0.13"}
+
+// TestForecastProvider checks provider which has a forecast for known city and
+// doesn't have one for unknown city. Empty unknown skips not found checks, for
+// providers which have every city.
+func TestForecastProvider(t *testing.T, p weathersrc.ForecastProvider, known, unknown string) {
+	t.Run("Known city", func(t *testing.T) {
+		forecast, err := p.GetForecast(known)
+		assert.Nil(t, err)
+		if assert.NotNil(t, forecast) {
+			assert.NotEmpty(t, forecast.Name)
+		}
+	})
+
+	if unknown != "" {
+		t.Run("Unknown city", func(t *testing.T) {
+			forecast, err := p.GetForecast(unknown)
+			assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+			assert.Nil(t, forecast)
+		})
+	}
+
+	if qp, ok := p.(weathersrc.QueryForecastProvider); ok {
+		t.Run("Query matches get", func(t *testing.T) {
+			want, err := p.GetForecast(known)
+			assert.Nil(t, err)
+			got, err := qp.QueryForecast(weather.Query{City: known})
+			assert.Nil(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+
+	t.Run("Concurrent reads", func(t *testing.T) {
+		parallel(20, func(i int) {
+			city := known
+			if i%2 == 1 && unknown != "" {
+				city = unknown
+			}
+			forecast, err := p.GetForecast(city)
+			if city == known {
+				assert.Nil(t, err)
+				assert.NotNil(t, forecast)
+			}
+		})
+	})
+}
+
+// TestErrors checks that every provider fails with error matching its key
+// with errors.Is, however it's wrapped, and returns no forecast
+func TestErrors(t *testing.T, city string, providers map[error]weathersrc.ForecastProvider) {
+	for want, p := range providers {
+		t.Run(want.Error(), func(t *testing.T) {
+			forecast, err := p.GetForecast(city)
+			assert.True(t, errors.Is(err, want), "want %v, got %v", want, err)
+			assert.Nil(t, forecast)
+
+			if qp, ok := p.(weathersrc.QueryForecastProvider); ok {
+				forecast, err = qp.QueryForecast(weather.Query{City: city})
+				assert.True(t, errors.Is(err, want), "want %v, got %v", want, err)
+				assert.Nil(t, forecast)
+			}
+		})
+	}
+}
+
+// TestWriteableForecastProvider checks storage semantics: not found errors,
+// round-trips, case-insensitive cities, deleting, listing, flushing, expiry
+// and concurrent use
+func TestWriteableForecastProvider(t *testing.T, newStorage NewStorage, forecast *weather.Forecast) {
+	const ttl = time.Hour
+
+	other := *forecast
+	other.Name = "Other " + forecast.Name
+	other.Dt = forecast.Dt + 600
+
+	t.Run("Not found", func(t *testing.T) {
+		p := newStorage(t, NewClock(), ttl)
+		got, err := p.GetForecast("London")
+		assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+		assert.Nil(t, got)
+
+		err = p.DeleteForecast("London")
+		assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+
+		entries, err := p.ListForecasts()
+		assert.Nil(t, err)
+		assert.Empty(t, entries)
+
+		if ep, ok := p.(weathersrc.EntryProvider); ok {
+			_, err = ep.GetEntry("London")
+			assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+		}
+	})
+
+	t.Run("Save and get", func(t *testing.T) {
+		p := newStorage(t, NewClock(), ttl)
+		for i, city := range cities {
+			f := forecast
+			if i%2 == 1 {
+				f = &other
+			}
+			assert.Nil(t, p.SaveForecast(city, f), city)
+		}
+		for i, city := range cities {
+			want := forecast
+			if i%2 == 1 {
+				want = &other
+			}
+			got, err := p.GetForecast(city)
+			assert.Nil(t, err, city)
+			assert.Equal(t, want, got, city)
+		}
+
+		// the last save wins
+		assert.Nil(t, p.SaveForecast("London", &other))
+		got, err := p.GetForecast("London")
+		assert.Nil(t, err)
+		assert.Equal(t, &other, got)
+	})
+
+	t.Run("Case insensitive", func(t *testing.T) {
+		p := newStorage(t, NewClock(), ttl)
+		assert.Nil(t, p.SaveForecast("Łódź", forecast))
+		got, err := p.GetForecast("ŁÓDŹ")
+		assert.Nil(t, err)
+		assert.Equal(t, forecast, got)
+
+		assert.Nil(t, p.SaveForecast("łódź", &other))
+		got, err = p.GetForecast("Łódź")
+		assert.Nil(t, err)
+		assert.Equal(t, &other, got)
+
+		entries, err := p.ListForecasts()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"łódź"}, entryCities(entries))
+
+		assert.Nil(t, p.DeleteForecast("ŁÓDŹ"))
+		_, err = p.GetForecast("łódź")
+		assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+	})
+
+	t.Run("Entries", func(t *testing.T) {
+		clock := NewClock()
+		p := newStorage(t, clock, ttl)
+		for _, city := range cities {
+			assert.Nil(t, p.SaveForecast(city, forecast))
+		}
+
+		entries, err := p.ListForecasts()
+		assert.Nil(t, err)
+		assert.Equal(t, sorted(cities), entryCities(entries))
+		for _, e := range entries {
+			assert.NotEmpty(t, e.Source)
+			assert.True(t, e.StoredAt.Equal(clock.Now()), "stored at %v", e.StoredAt)
+			assert.True(t, e.ExpiresAt.Equal(clock.Now().Add(ttl)), "expires at %v", e.ExpiresAt)
+		}
+
+		if ep, ok := p.(weathersrc.EntryProvider); ok {
+			e, err := ep.GetEntry("Łódź")
+			assert.Nil(t, err)
+			assert.Equal(t, "Łódź", e.City)
+			assert.True(t, e.ExpiresAt.Equal(clock.Now().Add(ttl)), "expires at %v", e.ExpiresAt)
+		}
+	})
+
+	t.Run("Delete and flush", func(t *testing.T) {
+		p := newStorage(t, NewClock(), ttl)
+		for _, city := range cities {
+			assert.Nil(t, p.SaveForecast(city, forecast))
+		}
+
+		assert.Nil(t, p.DeleteForecast("London"))
+		_, err := p.GetForecast("London")
+		assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+		_, err = p.GetForecast("london?lang=pl&units=metric")
+		assert.Nil(t, err, "deleting one key leaves the others")
+
+		assert.Nil(t, p.Flush())
+		entries, err := p.ListForecasts()
+		assert.Nil(t, err)
+		assert.Empty(t, entries)
+		_, err = p.GetForecast("New York")
+		assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		clock := NewClock()
+		p := newStorage(t, clock, ttl)
+		assert.Nil(t, p.SaveForecast("London", forecast))
+		clock.Advance(ttl / 2)
+		assert.Nil(t, p.SaveForecast("Warsaw", forecast))
+
+		clock.Advance(ttl/2 + time.Second)
+		_, err := p.GetForecast("London")
+		assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+		_, err = p.GetForecast("Warsaw")
+		assert.Nil(t, err)
+
+		entries, err := p.ListForecasts()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"Warsaw"}, entryCities(entries))
+
+		if ep, ok := p.(weathersrc.EntryProvider); ok {
+			_, err = ep.GetEntry("London")
+			assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+		}
+	})
+
+	t.Run("Concurrent use", func(t *testing.T) {
+		p := newStorage(t, NewClock(), ttl)
+		parallel(20, func(i int) {
+			city := fmt.Sprintf("city-%d", i%5)
+			for j := 0; j < 10; j++ {
+				assert.Nil(t, p.SaveForecast(city, forecast))
+				if got, err := p.GetForecast(city); err == nil {
+					assert.Equal(t, forecast, got)
+				} else {
+					// another goroutine could have just deleted it
+					assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+				}
+				if err := p.DeleteForecast(city); err != nil {
+					assert.True(t, errors.Is(err, weather.ErrForecastNotFound), "want not found, got %v", err)
+				}
+				_, err := p.ListForecasts()
+				assert.Nil(t, err)
+			}
+		})
+
+		for i := 0; i < 5; i++ {
+			assert.Nil(t, p.SaveForecast(fmt.Sprintf("city-%d", i), forecast))
+		}
+		entries, err := p.ListForecasts()
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"city-0", "city-1", "city-2", "city-3", "city-4"}, entryCities(entries))
+	})
+}
+
+func parallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// entryCities returns sorted, distinct cities of entries. Layered storages
+// list the same city once per layer.
+func entryCities(entries []weathersrc.Entry) []string {
+	seen := map[string]bool{}
+	var cities []string
+	for _, e := range entries {
+		if !seen[e.City] {
+			seen[e.City] = true
+			cities = append(cities, e.City)
+		}
+	}
+	return sorted(cities)
+}
+
+func sorted(items []string) []string {
+	s := append([]string(nil), items...)
+	sort.Strings(s)
+	return s
+}
@@ -0,0 +1,244 @@
+package weathersrc
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExternalFactory builds external provider from its settings
+type ExternalFactory func(settings Settings) (ForecastProvider, error)
+
+// StorageFactory builds storage provider from its settings
+type StorageFactory func(settings Settings) (WriteableForecastProvider, error)
+
+// ConfigKeys map provider settings to keys of service config they are read
+// from, like "ttl" to "cache_ttl". Adding a provider doesn't need changes in
+// the service then.
+type ConfigKeys map[string]string
+
+type externalEntry struct {
+	factory ExternalFactory
+	keys    ConfigKeys
+}
+
+type storageEntry struct {
+	factory StorageFactory
+	keys    ConfigKeys
+}
+
+// registry keeps provider factories by name
+type registry struct {
+	mu        sync.RWMutex
+	externals map[string]externalEntry
+	storages  map[string]storageEntry
+}
+
+func newRegistry() *registry {
+	return &registry{
+		externals: map[string]externalEntry{},
+		storages:  map[string]storageEntry{},
+	}
+}
+
+// providers is where provider packages register themselves
+var providers = newRegistry()
+
+// RegisterExternal makes external provider available by name, built from
+// settings read from given config keys. It's meant to be called from provider
+// package init and panics if the name is already taken.
+func RegisterExternal(name string, factory ExternalFactory, keys ConfigKeys) {
+	providers.registerExternal(name, externalEntry{factory: factory, keys: keys})
+}
+
+// RegisterStorage makes storage provider available by name, built from
+// settings read from given config keys. It's meant to be called from provider
+// package init and panics if the name is already taken.
+func RegisterStorage(name string, factory StorageFactory, keys ConfigKeys) {
+	providers.registerStorage(name, storageEntry{factory: factory, keys: keys})
+}
+
+// NewExternal builds registered external provider
+func NewExternal(name string, settings Settings) (ForecastProvider, error) {
+	return providers.newExternal(name, settings)
+}
+
+// NewStorage builds registered storage provider
+func NewStorage(name string, settings Settings) (WriteableForecastProvider, error) {
+	return providers.newStorage(name, settings)
+}
+
+// ExternalProviders returns sorted names of registered external providers
+func ExternalProviders() []string {
+	return providers.externalProviders()
+}
+
+// StorageProviders returns sorted names of registered storage providers
+func StorageProviders() []string {
+	return providers.storageProviders()
+}
+
+// ExternalConfigKeys returns config keys registered external provider reads,
+// nil if there is no such provider
+func ExternalConfigKeys(name string) ConfigKeys {
+	return providers.externalConfigKeys(name)
+}
+
+// StorageConfigKeys returns config keys registered storage provider reads,
+// nil if there is no such provider
+func StorageConfigKeys(name string) ConfigKeys {
+	return providers.storageConfigKeys(name)
+}
+
+func (r *registry) registerExternal(name string, entry externalEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, taken := r.externals[name]; taken {
+		panic("weathersrc: external provider " + name + " registered twice")
+	}
+	r.externals[name] = entry
+}
+
+func (r *registry) registerStorage(name string, entry storageEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, taken := r.storages[name]; taken {
+		panic("weathersrc: storage provider " + name + " registered twice")
+	}
+	r.storages[name] = entry
+}
+
+func (r *registry) newExternal(name string, settings Settings) (ForecastProvider, error) {
+	r.mu.RLock()
+	entry, found := r.externals[name]
+	r.mu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("unknown external provider %q, available: %s", name, strings.Join(r.externalProviders(), ", "))
+	}
+	provider, err := entry.factory(settings)
+	if err != nil {
+		return nil, fmt.Errorf("external provider %s: %w", name, err)
+	}
+	return provider, nil
+}
+
+func (r *registry) newStorage(name string, settings Settings) (WriteableForecastProvider, error) {
+	r.mu.RLock()
+	entry, found := r.storages[name]
+	r.mu.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("unknown storage provider %q, available: %s", name, strings.Join(r.storageProviders(), ", "))
+	}
+	provider, err := entry.factory(settings)
+	if err != nil {
+		return nil, fmt.Errorf("storage provider %s: %w", name, err)
+	}
+	return provider, nil
+}
+
+func (r *registry) externalProviders() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.externals))
+	for name := range r.externals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *registry) storageProviders() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.storages))
+	for name := range r.storages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *registry) externalConfigKeys(name string) ConfigKeys {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.externals[name].keys
+}
+
+func (r *registry) storageConfigKeys(name string) ConfigKeys {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.storages[name].keys
+}
+
+// Settings are provider options by name, like "ttl" or "dir". Getters return
+// zero value for missing keys and errors naming the key for invalid ones.
+type Settings map[string]string
+
+// settingRead is called with every key read from settings, tests use it to
+// check providers declare all keys they read in ConfigKeys
+var settingRead = func(key string) {}
+
+func (s Settings) get(key string) string {
+	settingRead(key)
+	return s[key]
+}
+
+func (s Settings) String(key string) string {
+	return s.get(key)
+}
+
+// List returns comma-separated values, empty ones are skipped
+func (s Settings) List(key string) []string {
+	var items []string
+	for _, item := range strings.Split(s.get(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (s Settings) Duration(key string) (time.Duration, error) {
+	value := s.get(key)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q", key, value)
+	}
+	return d, nil
+}
+
+func (s Settings) Int(key string) (int64, error) {
+	value := s.get(key)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number %q", key, value)
+	}
+	return n, nil
+}
+
+func (s Settings) Float(key string) (float64, error) {
+	value := s.get(key)
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number %q", key, value)
+	}
+	return f, nil
+}
@@ -0,0 +1,72 @@
+package weathersrc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticProvider struct {
+	dir string
+}
+
+func (p *staticProvider) GetForecast(city string) (*weather.Forecast, error) {
+	return nil, weather.ErrForecastNotFound
+}
+
+func TestRegistry(t *testing.T) {
+	r := newRegistry()
+	r.registerExternal("static", externalEntry{factory: func(s Settings) (ForecastProvider, error) {
+		if s.String("dir") == "" {
+			return nil, errors.New("dir is required")
+		}
+		return &staticProvider{dir: s.String("dir")}, nil
+	}, keys: ConfigKeys{"dir": "static_dir"}})
+
+	assert.Equal(t, []string{"static"}, r.externalProviders())
+	assert.Empty(t, r.storageProviders())
+	assert.Equal(t, ConfigKeys{"dir": "static_dir"}, r.externalConfigKeys("static"))
+	assert.Nil(t, r.storageConfigKeys("static"))
+	assert.Panics(t, func() {
+		r.registerExternal("static", externalEntry{})
+	})
+
+	p, err := r.newExternal("static", Settings{"dir": "/tmp"})
+	assert.Nil(t, err)
+	assert.Equal(t, &staticProvider{dir: "/tmp"}, p)
+
+	_, err = r.newExternal("static", Settings{})
+	assert.EqualError(t, err, "external provider static: dir is required")
+
+	_, err = r.newExternal("missing", Settings{})
+	assert.EqualError(t, err, `unknown external provider "missing", available: static`)
+
+	_, err = r.newStorage("missing", Settings{})
+	assert.EqualError(t, err, `unknown storage provider "missing", available: `)
+}
+
+func TestSettings(t *testing.T) {
+	s := Settings{"ttl": "5m", "max": "10", "keys": "a, ,b", "bad": "x"}
+
+	d, err := s.Duration("ttl")
+	assert.Nil(t, err)
+	assert.Equal(t, 5*time.Minute, d)
+
+	n, err := s.Int("max")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), n)
+
+	n, err = s.Int("missing")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), n)
+
+	_, err = s.Duration("bad")
+	assert.EqualError(t, err, `bad: invalid duration "x"`)
+	_, err = s.Int("bad")
+	assert.EqualError(t, err, `bad: invalid number "x"`)
+
+	assert.Equal(t, []string{"a", "b"}, s.List("keys"))
+}
@@ -0,0 +1,300 @@
+package synthetic
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+)
+
+// defaultPeriod is how long a generated forecast stays the same, OpenWeather
+// updates its data about as often
+const defaultPeriod = 10 * time.Minute
+
+// Source is the name synthetic forecasts are registered and reported under
+const Source = "synthetic"
+
+// SyntheticWeatherSrc generates plausible forecasts for any city, e.g. for
+// load testing. The same city gets the same forecast within a period. Latency
+// and errors can be injected to exercise retries and partial results.
+type SyntheticWeatherSrc struct {
+	seed       int64
+	period     time.Duration
+	minLatency time.Duration
+	maxLatency time.Duration
+	errorRate  float64
+
+	// mu guards rnd used for injected latency and errors
+	mu    sync.Mutex
+	rnd   *rand.Rand
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+type Option func(provider *SyntheticWeatherSrc)
+
+func NewWeatherSrc(opts ...Option) *SyntheticWeatherSrc {
+	provider := &SyntheticWeatherSrc{
+		period: defaultPeriod,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+
+	for _, opt := range opts {
+		opt(provider)
+	}
+	provider.rnd = rand.New(rand.NewSource(provider.seed))
+
+	return provider
+}
+
+// WithSeed changes generated forecasts and the sequence of injected faults
+func WithSeed(seed int64) Option {
+	return func(provider *SyntheticWeatherSrc) {
+		provider.seed = seed
+	}
+}
+
+// WithPeriod sets how long a forecast for a city stays the same
+func WithPeriod(period time.Duration) Option {
+	return func(provider *SyntheticWeatherSrc) {
+		if period > 0 {
+			provider.period = period
+		}
+	}
+}
+
+// WithLatency delays every call by a random time between min and max
+func WithLatency(min, max time.Duration) Option {
+	return func(provider *SyntheticWeatherSrc) {
+		provider.minLatency = min
+		provider.maxLatency = max
+	}
+}
+
+// WithErrorRate makes given share of calls, from 0 to 1, fail with one of
+// the errors external providers return: unavailable, timeout or rate limit
+func WithErrorRate(rate float64) Option {
+	return func(provider *SyntheticWeatherSrc) {
+		provider.errorRate = rate
+	}
+}
+
+// injectedErrors are returned for failing calls
+var injectedErrors = []error{
+	weather.ErrUnavailable,
+	weather.ErrTimeout,
+	&weather.RetryAfterError{Err: weather.ErrTooManyRequests, RetryAfter: time.Second},
+}
+
+func (p *SyntheticWeatherSrc) Name() string {
+	return Source
+}
+
+func (p *SyntheticWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	return p.QueryForecast(weather.Query{City: city})
+}
+
+func (p *SyntheticWeatherSrc) QueryForecast(q weather.Query) (*weather.Forecast, error) {
+	p.mu.Lock()
+	latency := p.minLatency
+	if p.maxLatency > p.minLatency {
+		latency += time.Duration(p.rnd.Int63n(int64(p.maxLatency - p.minLatency)))
+	}
+	var err error
+	if p.errorRate > 0 && p.rnd.Float64() < p.errorRate {
+		err = injectedErrors[p.rnd.Intn(len(injectedErrors))]
+	}
+	p.mu.Unlock()
+
+	if latency > 0 {
+		p.sleep(latency)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p.generate(q, p.now().Truncate(p.period)), nil
+}
+
+type condition struct {
+	id          int
+	main        string
+	description string
+	icon        string
+}
+
+var (
+	clear        = condition{800, "Clear", "clear sky", "01"}
+	cloudy       = []condition{{801, "Clouds", "few clouds", "02"}, {802, "Clouds", "scattered clouds", "03"}, {803, "Clouds", "broken clouds", "04"}, {804, "Clouds", "overcast clouds", "04"}}
+	rain         = []condition{{500, "Rain", "light rain", "10"}, {501, "Rain", "moderate rain", "10"}}
+	snow         = condition{600, "Snow", "light snow", "13"}
+	thunderstorm = condition{211, "Thunderstorm", "thunderstorm", "11"}
+	mist         = condition{701, "Mist", "mist", "50"}
+)
+
+// generate builds forecast from random numbers seeded with location and time
+func (p *SyntheticWeatherSrc) generate(q weather.Query, at time.Time) *weather.Forecast {
+	location := strings.ToLower(q.Location())
+	h := fnv.New64a()
+	h.Write([]byte(location))
+	cityHash := h.Sum64()
+
+	// place depends only on the city, weather also on time
+	place := rand.New(rand.NewSource(int64(cityHash) ^ p.seed))
+	rnd := rand.New(rand.NewSource(int64(cityHash) ^ p.seed ^ at.Unix()))
+
+	f := &weather.Forecast{}
+	f.Base = "stations"
+	f.Cod = 200
+	f.Dt = int(at.Unix())
+
+	f.Coord.Lat = round(place.Float64()*130-60, 2)
+	f.Coord.Lon = round(place.Float64()*360-180, 2)
+	if q.Coord != nil {
+		f.Coord.Lat, f.Coord.Lon = q.Coord.Lat, q.Coord.Lon
+	}
+	f.ID = 1000000 + int(cityHash%9000000)
+	if q.ID != 0 {
+		f.ID = q.ID
+	}
+	f.Name = name(q)
+	f.Sys.Country = string([]byte{byte('A' + place.Intn(26)), byte('A' + place.Intn(26))})
+	f.Sys.Type = 1
+	f.Sys.ID = 1000 + place.Intn(9000)
+
+	// colder far from equator, warmest in the afternoon of local solar time
+	localHour := math.Mod(float64(at.UTC().Hour())+f.Coord.Lon/15+24, 24)
+	kelvin := 300 - 0.45*math.Abs(f.Coord.Lat) + 5*math.Sin(2*math.Pi*(localHour-9)/24) + rnd.NormFloat64()*3
+	spread := 0.5 + rnd.Float64()*3
+	f.Main.Temp = round(convert(kelvin, q.Units), 2)
+	f.Main.TempMin = round(convert(kelvin-spread, q.Units), 2)
+	f.Main.TempMax = round(convert(kelvin+spread, q.Units), 2)
+	f.Main.Pressure = 980 + rnd.Intn(60)
+	f.Main.Humidity = 20 + rnd.Intn(81)
+
+	f.Clouds.All = rnd.Intn(101)
+	f.Wind.Speed = round(rnd.Float64()*15, 1)
+	if q.Units == "imperial" {
+		f.Wind.Speed = round(f.Wind.Speed*2.237, 1)
+	}
+	f.Wind.Deg = rnd.Intn(360)
+	f.Visibility = 10000
+	if f.Main.Humidity > 90 {
+		f.Visibility = 1000 + rnd.Intn(4000)
+	}
+
+	c := pickCondition(rnd, f.Clouds.All, f.Main.Humidity, kelvin)
+	icon := c.icon + "d"
+	if localHour < 6 || localHour >= 20 {
+		icon = c.icon + "n"
+	}
+	f.Weather = make([]struct {
+		ID          int    `json:"id" xml:"id"`
+		Main        string `json:"main" xml:"main"`
+		Description string `json:"description" xml:"description"`
+		Icon        string `json:"icon" xml:"icon"`
+	}, 1)
+	f.Weather[0].ID = c.id
+	f.Weather[0].Main = c.main
+	f.Weather[0].Description = c.description
+	f.Weather[0].Icon = icon
+
+	midnight := at.UTC().Truncate(24 * time.Hour)
+	solarNoon := midnight.Add(time.Duration((12 - f.Coord.Lon/15) * float64(time.Hour)))
+	f.Sys.Sunrise = int(solarNoon.Add(-6 * time.Hour).Unix())
+	f.Sys.Sunset = int(solarNoon.Add(6 * time.Hour).Unix())
+
+	return f
+}
+
+func pickCondition(rnd *rand.Rand, clouds, humidity int, kelvin float64) condition {
+	switch {
+	case humidity > 95:
+		return mist
+	case clouds < 10:
+		return clear
+	case clouds > 85 && humidity > 70:
+		if kelvin < 273.15 {
+			return snow
+		}
+		if rnd.Intn(10) == 0 {
+			return thunderstorm
+		}
+		return rain[rnd.Intn(len(rain))]
+	}
+	return cloudy[clouds*len(cloudy)/101]
+}
+
+func name(q weather.Query) string {
+	if q.City == "" {
+		return "Synthetic " + q.Location()
+	}
+	return strings.Title(strings.Join(strings.Fields(q.City), " "))
+}
+
+// convert turns kelvins into given units, like OpenWeather does
+func convert(kelvin float64, units string) float64 {
+	switch units {
+	case "metric":
+		return kelvin - 273.15
+	case "imperial":
+		return (kelvin-273.15)*9/5 + 32
+	}
+	return kelvin
+}
+
+func round(v float64, places int) float64 {
+	pow := math.Pow(10, float64(places))
+	return math.Round(v*pow) / pow
+}
+
+func init() {
+	weathersrc.RegisterExternal(Source, newFromSettings, weathersrc.ConfigKeys{
+		"seed":        "synthetic_seed",
+		"period":      "synthetic_period",
+		"min_latency": "synthetic_min_latency",
+		"max_latency": "synthetic_latency",
+		"error_rate":  "synthetic_error_rate",
+	})
+}
+
+// newFromSettings builds provider from seed, period, min_latency, max_latency
+// and error_rate
+func newFromSettings(s weathersrc.Settings) (weathersrc.ForecastProvider, error) {
+	seed, err := s.Int("seed")
+	if err != nil {
+		return nil, err
+	}
+	period, err := s.Duration("period")
+	if err != nil {
+		return nil, err
+	}
+	minLatency, err := s.Duration("min_latency")
+	if err != nil {
+		return nil, err
+	}
+	maxLatency, err := s.Duration("max_latency")
+	if err != nil {
+		return nil, err
+	}
+	errorRate, err := s.Float("error_rate")
+	if err != nil {
+		return nil, err
+	}
+	if errorRate < 0 || errorRate > 1 {
+		return nil, errors.New("error_rate: must be between 0 and 1")
+	}
+
+	return NewWeatherSrc(
+		WithSeed(seed),
+		WithPeriod(period),
+		WithLatency(minLatency, maxLatency),
+		WithErrorRate(errorRate),
+	), nil
+}
@@ -0,0 +1,115 @@
+package synthetic
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyntheticWeatherSrc_Deterministic(t *testing.T) {
+	now := time.Date(2020, 4, 27, 19, 42, 0, 0, time.UTC)
+	p := NewWeatherSrc(WithSeed(42))
+	p.now = func() time.Time { return now }
+
+	london, err := p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, "London", london.Name)
+	assert.Equal(t, int(now.Truncate(defaultPeriod).Unix()), london.Dt)
+	assert.Len(t, london.Weather, 1)
+	assert.True(t, london.Main.TempMin <= london.Main.Temp && london.Main.Temp <= london.Main.TempMax)
+
+	// the same city within a period, case doesn't matter
+	now = now.Add(5 * time.Minute)
+	again, _ := p.GetForecast("london")
+	again.Name = london.Name
+	assert.Equal(t, london, again)
+
+	// another instance with the same seed generates the same forecasts
+	other := NewWeatherSrc(WithSeed(42))
+	other.now = p.now
+	again, _ = other.GetForecast("London")
+	assert.Equal(t, london, again)
+
+	warsaw, _ := p.GetForecast("Warsaw")
+	assert.NotEqual(t, london.Coord, warsaw.Coord)
+
+	// weather changes with time, place stays
+	now = now.Add(time.Hour)
+	later, _ := p.GetForecast("London")
+	assert.Equal(t, london.Coord, later.Coord)
+	assert.NotEqual(t, london.Main, later.Main)
+}
+
+func TestSyntheticWeatherSrc_QueryForecast(t *testing.T) {
+	p := NewWeatherSrc()
+
+	standard, _ := p.QueryForecast(weather.Query{City: "London"})
+	metric, _ := p.QueryForecast(weather.Query{City: "London", Units: "metric"})
+	imperial, _ := p.QueryForecast(weather.Query{City: "London", Units: "imperial"})
+	assert.InDelta(t, standard.Main.Temp-273.15, metric.Main.Temp, 0.02)
+	assert.InDelta(t, metric.Main.Temp*9/5+32, imperial.Main.Temp, 0.05)
+
+	byID, _ := p.QueryForecast(weather.Query{ID: 2643743})
+	assert.Equal(t, 2643743, byID.ID)
+
+	byCoord, _ := p.QueryForecast(weather.Query{Coord: &weather.Coord{Lat: 51.51, Lon: -0.13}})
+	assert.Equal(t, 51.51, byCoord.Coord.Lat)
+	assert.Equal(t, -0.13, byCoord.Coord.Lon)
+}
+
+func TestSyntheticWeatherSrc_Faults(t *testing.T) {
+	tests := []struct {
+		name       string
+		errorRate  float64
+		wantErrors int
+	}{
+		{name: "No errors", errorRate: 0, wantErrors: 0},
+		{name: "All calls fail", errorRate: 1, wantErrors: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var slept []time.Duration
+			p := NewWeatherSrc(
+				WithLatency(10*time.Millisecond, 20*time.Millisecond),
+				WithErrorRate(tt.errorRate),
+			)
+			p.sleep = func(d time.Duration) { slept = append(slept, d) }
+
+			errs := 0
+			for i := 0; i < 100; i++ {
+				_, err := p.GetForecast("London")
+				if err != nil {
+					errs++
+					assert.True(t, errors.Is(err, weather.ErrUnavailable) ||
+						errors.Is(err, weather.ErrTimeout) ||
+						errors.Is(err, weather.ErrTooManyRequests))
+				}
+			}
+			assert.Equal(t, tt.wantErrors, errs)
+
+			assert.Len(t, slept, 100)
+			for _, d := range slept {
+				assert.True(t, d >= 10*time.Millisecond && d < 20*time.Millisecond)
+			}
+		})
+	}
+
+	// partial failures
+	p := NewWeatherSrc(WithErrorRate(0.3))
+	errs := 0
+	for i := 0; i < 1000; i++ {
+		if _, err := p.GetForecast("London"); err != nil {
+			errs++
+		}
+	}
+	assert.InDelta(t, 300, errs, 60)
+}
+
+func TestSyntheticWeatherSrc_Contract(t *testing.T) {
+	// synthetic provider has every city
+	providertest.TestForecastProvider(t, NewWeatherSrc(), "London", "")
+}
@@ -0,0 +1,175 @@
+package tiered
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/weathersrc"
+)
+
+// TieredWeatherSrc layers several storage providers, e.g. in-memory cache in
+// front of a shared store. Reads go through tiers in order and a hit in a lower
+// tier is copied to all tiers above it, expiring no later than in the tier it
+// was found in. Writes go to every tier. Each tier keeps its own TTL.
+type TieredWeatherSrc struct {
+	tiers []weathersrc.WriteableForecastProvider
+}
+
+type Option func(provider *TieredWeatherSrc)
+
+func NewWeatherSrc(opts ...Option) *TieredWeatherSrc {
+	provider := &TieredWeatherSrc{}
+
+	for _, opt := range opts {
+		opt(provider)
+	}
+
+	return provider
+}
+
+// WithTier appends a tier below already added ones
+func WithTier(tier weathersrc.WriteableForecastProvider) Option {
+	return func(provider *TieredWeatherSrc) {
+		provider.tiers = append(provider.tiers, tier)
+	}
+}
+
+func (p *TieredWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	for i, tier := range p.tiers {
+		forecast, err := tier.GetForecast(city)
+		if err != nil {
+			if !errors.Is(err, weather.ErrForecastNotFound) {
+				return nil, fmt.Errorf("error fetching forecast from tier %d for %s: %w", i, city, err)
+			}
+			continue
+		}
+
+		if i > 0 {
+			p.backFill(i, city, forecast)
+		}
+		return forecast, nil
+	}
+
+	return nil, weather.ErrForecastNotFound
+}
+
+// backFill copies forecast found in tier i to tiers above it. Tiers which can
+// expire it earlier than their TTL keep it only as long as tier i does, so
+// the stack doesn't serve it longer than the lowest tier would.
+func (p *TieredWeatherSrc) backFill(i int, city string, forecast *weather.Forecast) {
+	var expiresAt time.Time
+	if entries, ok := p.tiers[i].(weathersrc.EntryProvider); ok {
+		entry, err := entries.GetEntry(city)
+		if err != nil {
+			// it has expired since it was read
+			return
+		}
+		expiresAt = entry.ExpiresAt
+	}
+
+	for j := 0; j < i; j++ {
+		var err error
+		if tier, ok := p.tiers[j].(weathersrc.ExpiringStorage); ok {
+			err = tier.SaveForecastUntil(city, forecast, expiresAt)
+		} else {
+			err = p.tiers[j].SaveForecast(city, forecast)
+		}
+		if err != nil {
+			log.Printf("unable to back-fill tier %d for %s: %v", j, city, err)
+		}
+	}
+}
+
+// SaveForecast writes forecast to all tiers. It tries every tier even if some of them fail.
+func (p *TieredWeatherSrc) SaveForecast(city string, forecast *weather.Forecast) error {
+	var firstErr error
+	for i, tier := range p.tiers {
+		if err := tier.SaveForecast(city, forecast); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error saving forecast to tier %d for %s: %w", i, city, err)
+		}
+	}
+	return firstErr
+}
+
+// GetEntry describes forecast from the first tier which has it and can
+// describe it. Forecasts copied to faster tiers on read are stored again, so
+// it reports the earliest time any tier stored the forecast.
+func (p *TieredWeatherSrc) GetEntry(city string) (weathersrc.Entry, error) {
+	var found *weathersrc.Entry
+	for i, tier := range p.tiers {
+		entries, ok := tier.(weathersrc.EntryProvider)
+		if !ok {
+			continue
+		}
+
+		entry, err := entries.GetEntry(city)
+		if err != nil {
+			if !errors.Is(err, weather.ErrForecastNotFound) {
+				return weathersrc.Entry{}, fmt.Errorf("error fetching entry from tier %d for %s: %w", i, city, err)
+			}
+			continue
+		}
+		switch {
+		case found == nil:
+			found = &entry
+		case entry.StoredAt.Before(found.StoredAt):
+			found.StoredAt = entry.StoredAt
+		}
+	}
+
+	if found == nil {
+		return weathersrc.Entry{}, weather.ErrForecastNotFound
+	}
+	return *found, nil
+}
+
+// DeleteForecast removes forecast from all tiers
+func (p *TieredWeatherSrc) DeleteForecast(city string) error {
+	var firstErr error
+	found := false
+	for i, tier := range p.tiers {
+		err := tier.DeleteForecast(city)
+		switch {
+		case err == nil:
+			found = true
+		case errors.Is(err, weather.ErrForecastNotFound):
+		case firstErr == nil:
+			firstErr = fmt.Errorf("error deleting forecast from tier %d for %s: %w", i, city, err)
+		}
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	if !found {
+		return weather.ErrForecastNotFound
+	}
+	return nil
+}
+
+// ListForecasts returns entries of all tiers, tier by tier
+func (p *TieredWeatherSrc) ListForecasts() ([]weathersrc.Entry, error) {
+	entries := []weathersrc.Entry{}
+	for i, tier := range p.tiers {
+		tierEntries, err := tier.ListForecasts()
+		if err != nil {
+			return nil, fmt.Errorf("error listing forecasts from tier %d: %w", i, err)
+		}
+		entries = append(entries, tierEntries...)
+	}
+	return entries, nil
+}
+
+// Flush removes forecasts from all tiers
+func (p *TieredWeatherSrc) Flush() error {
+	var firstErr error
+	for i, tier := range p.tiers {
+		if err := tier.Flush(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error flushing tier %d: %w", i, err)
+		}
+	}
+	return firstErr
+}
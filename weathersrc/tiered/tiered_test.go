@@ -0,0 +1,176 @@
+package tiered
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/papisz/weather"
+	"github.com/papisz/weather/testutils"
+	"github.com/papisz/weather/weathersrc"
+	"github.com/papisz/weather/weathersrc/cache"
+	"github.com/papisz/weather/weathersrc/chaos"
+	"github.com/papisz/weather/weathersrc/providertest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTieredWeatherSrc_GetForecast(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+
+	tests := []struct {
+		name        string
+		l1          *cache.CacheWeatherSrc
+		l2          *cache.CacheWeatherSrc
+		want        *weather.Forecast
+		expectedErr error
+		wantL1      bool
+	}{
+		{
+			name:   "L1 hit",
+			l1:     newCache("London", london),
+			l2:     newCache("", nil),
+			want:   london,
+			wantL1: true,
+		},
+		{
+			name:   "L1 miss, L2 hit back-fills L1",
+			l1:     newCache("", nil),
+			l2:     newCache("London", london),
+			want:   london,
+			wantL1: true,
+		},
+		{
+			name:        "Miss in all tiers",
+			l1:          newCache("", nil),
+			l2:          newCache("", nil),
+			expectedErr: weather.ErrForecastNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewWeatherSrc(WithTier(tt.l1), WithTier(tt.l2))
+
+			got, err := p.GetForecast("London")
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.want, got)
+
+			_, err = tt.l1.GetForecast("London")
+			assert.Equal(t, tt.wantL1, err == nil)
+		})
+	}
+}
+
+func TestTieredWeatherSrc_Errors(t *testing.T) {
+	london := testutils.ForecastFromJSON("london.json")
+	l1 := newCache("", nil)
+	broken := &brokenWeatherSrc{err: errors.New("connection refused")}
+	l3 := newCache("", nil)
+
+	p := NewWeatherSrc(WithTier(l1), WithTier(broken), WithTier(l3))
+
+	_, err := p.GetForecast("London")
+	assert.EqualError(t, err, "error fetching forecast from tier 1 for London: connection refused")
+
+	err = p.SaveForecast("London", london)
+	assert.EqualError(t, err, "error saving forecast to tier 1 for London: connection refused")
+
+	// healthy tiers are still written
+	got, err := l3.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, london, got)
+	got, err = p.GetForecast("London")
+	assert.Nil(t, err)
+	assert.Equal(t, london, got)
+}
+
+func newCache(city string, forecast *weather.Forecast) *cache.CacheWeatherSrc {
+	c := cache.NewWeatherSrc(cache.WithTTL(5 * time.Second))
+	if forecast != nil {
+		c.SaveForecast(city, forecast)
+	}
+	return c
+}
+
+type brokenWeatherSrc struct {
+	err error
+}
+
+func (p *brokenWeatherSrc) GetForecast(city string) (*weather.Forecast, error) {
+	return nil, p.err
+}
+
+func (p *brokenWeatherSrc) SaveForecast(city string, forecast *weather.Forecast) error {
+	return p.err
+}
+
+func (p *brokenWeatherSrc) DeleteForecast(city string) error {
+	return p.err
+}
+
+func (p *brokenWeatherSrc) ListForecasts() ([]weathersrc.Entry, error) {
+	return nil, p.err
+}
+
+func (p *brokenWeatherSrc) Flush() error {
+	return p.err
+}
+
+func TestTieredWeatherSrc_GetEntry(t *testing.T) {
+	clock := providertest.NewClock()
+	l1 := cache.NewWeatherSrc(cache.WithTTL(time.Hour), cache.WithClock(clock.Now))
+	l2 := cache.NewWeatherSrc(cache.WithTTL(time.Hour), cache.WithClock(clock.Now))
+	p := NewWeatherSrc(WithTier(l1), WithTier(l2))
+
+	storedAt := clock.Now()
+	assert.Nil(t, l2.SaveForecast("London", testutils.ForecastFromJSON("london.json")))
+	clock.Advance(time.Minute)
+
+	// back-fill stores forecast in L1 again, but it isn't any fresher
+	_, err := p.GetForecast("London")
+	assert.Nil(t, err)
+	entry, err := p.GetEntry("London")
+	assert.Nil(t, err)
+	assert.Equal(t, storedAt, entry.StoredAt)
+	assert.Equal(t, cache.Source, entry.Source)
+}
+
+func TestTieredWeatherSrc_BackFillExpiry(t *testing.T) {
+	clock := providertest.NewClock()
+	l1 := cache.NewWeatherSrc(cache.WithTTL(time.Hour), cache.WithClock(clock.Now))
+	l2 := cache.NewWeatherSrc(cache.WithTTL(10*time.Minute), cache.WithClock(clock.Now))
+	p := NewWeatherSrc(WithTier(l1), WithTier(l2))
+
+	expiresAt := clock.Now().Add(10 * time.Minute)
+	assert.Nil(t, l2.SaveForecast("London", testutils.ForecastFromJSON("london.json")))
+	clock.Advance(9 * time.Minute)
+
+	// forecast copied to L1 expires with the one in L2, not with L1 TTL
+	_, err := p.GetForecast("London")
+	assert.Nil(t, err)
+	entry, err := l1.GetEntry("London")
+	assert.Nil(t, err)
+	assert.Equal(t, expiresAt, entry.ExpiresAt)
+
+	clock.Advance(time.Minute)
+	_, err = p.GetForecast("London")
+	assert.Equal(t, weather.ErrForecastNotFound, err)
+}
+
+func TestTieredWeatherSrc_Contract(t *testing.T) {
+	providertest.TestWriteableForecastProvider(t, func(t *testing.T, clock *providertest.Clock, ttl time.Duration) weathersrc.WriteableForecastProvider {
+		return NewWeatherSrc(
+			WithTier(cache.NewWeatherSrc(cache.WithTTL(ttl), cache.WithClock(clock.Now))),
+			WithTier(cache.NewWeatherSrc(cache.WithTTL(ttl), cache.WithClock(clock.Now))),
+		)
+	}, testutils.ForecastFromJSON("london.json"))
+}
+
+func TestTieredWeatherSrc_ErrorWrapping(t *testing.T) {
+	providertest.TestErrors(t, "London", map[error]weathersrc.ForecastProvider{
+		weather.ErrForecastNotFound: NewWeatherSrc(WithTier(newCache("", nil)), WithTier(newCache("", nil))),
+		weather.ErrUnavailable: NewWeatherSrc(
+			WithTier(newCache("", nil)),
+			WithTier(chaos.NewWriteableWeatherSrc(newCache("", nil), chaos.WithError(1, weather.ErrUnavailable))),
+		),
+	})
+}